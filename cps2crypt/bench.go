@@ -0,0 +1,42 @@
+package cps2crypt
+
+import (
+	"fmt"
+	"time"
+)
+
+// CipherBenchmark is how long the reference and table driven Ciphers took to
+// decrypt and encrypt the same maincpu image.
+type CipherBenchmark struct {
+	ReferenceTime time.Duration
+	TableTime     time.Duration
+}
+
+// BenchmarkCipher decrypts and encrypts a big endian maincpu image with both
+// the reference and the table driven Cipher for key, and returns an error if
+// their output differs.
+func BenchmarkCipher(key *Key, romBinary []uint8, workers int) (*CipherBenchmark, error) {
+	reference := NewReferenceCipher(key)
+	reference.Workers = workers
+	table := NewCipher(key)
+	table.Workers = workers
+	words := createUint16ArrayFromUint8Array(romBinary)
+	var benchmark CipherBenchmark
+	for _, direction := range []Direction{Decrypt, Encrypt} {
+		start := time.Now()
+		expected := reference.crypt(direction, words, 0)
+		benchmark.ReferenceTime += time.Since(start)
+		start = time.Now()
+		actual := table.crypt(direction, words, 0)
+		benchmark.TableTime += time.Since(start)
+		err := CompareImages(createUint8ArrayFromUint16Array(expected, Decrypt), createUint8ArrayFromUint16Array(actual, Decrypt), 0)
+		if err != nil {
+			name := "decrypted"
+			if direction == Encrypt {
+				name = "encrypted"
+			}
+			return &benchmark, fmt.Errorf("table driven cipher %s differently, %w", name, err)
+		}
+	}
+	return &benchmark, nil
+}
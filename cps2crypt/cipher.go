@@ -0,0 +1,203 @@
+package cps2crypt
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// Cipher encrypts and decrypts maincpu words for a single key. Once Workers is
+// set it holds no mutable state, so one Cipher may be shared between
+// goroutines.
+type Cipher struct {
+	Key Key
+	// Workers is how many goroutines split the 0x10000 seeds between them.
+	// Zero or less means one per CPU.
+	Workers   int
+	reference bool
+	key1      [4]uint32
+	rounds    [4][256]uint8
+	key2      [4]uint32
+}
+
+func NewCipher(key *Key) *Cipher {
+	c := Cipher{Key: *key}
+	c.key1 = firstKey(c.Key.MasterKey)
+	c.rounds = firstRounds(c.key1)
+	copy(c.key2[:], expandSecondKey(c.Key.MasterKey[:]))
+	return &c
+}
+
+// NewReferenceCipher returns a Cipher that runs the feistel networks bit by
+// bit like MAME does. It's much slower and only there to check the table
+// driven Cipher against.
+func NewReferenceCipher(key *Key) *Cipher {
+	c := NewCipher(key)
+	c.reference = true
+	return c
+}
+
+// firstKey makes the round keys of the first feistel network, which turns a
+// word index into a seed.
+func firstKey(masterKey [2]uint32) [4]uint32 {
+	key1 := make([]uint32, 4)
+	expandKey(0, &key1, masterKey[:])
+
+	key1[0] ^= bit32(key1[0], 1) << 4
+	key1[0] ^= bit32(key1[0], 2) << 5
+	key1[0] ^= bit32(key1[0], 8) << 11
+	key1[1] ^= bit32(key1[1], 0) << 5
+	key1[1] ^= bit32(key1[1], 8) << 11
+	key1[2] ^= bit32(key1[2], 1) << 5
+	key1[2] ^= bit32(key1[2], 8) << 11
+
+	return [4]uint32(key1)
+}
+
+func referenceSeed(key1 [4]uint32, i int) uint16 {
+	return feistel(uint16(i&0xffff), fn1_groupA, fn1_groupB,
+		optimizedSBoxes[0], optimizedSBoxes[1], optimizedSBoxes[2], optimizedSBoxes[3],
+		key1[0], key1[1], key1[2], key1[3])
+}
+
+func (c *Cipher) referenceKey2(i int) [4]uint32 {
+	subkey := make([]uint32, 2)
+	expandSubkey(&subkey, referenceSeed(c.key1, i))
+
+	subkey[0] ^= c.Key.MasterKey[0]
+	subkey[1] ^= c.Key.MasterKey[1]
+
+	return [4]uint32(expandSecondKey(subkey))
+}
+
+// expandSecondKey turns the 64 bit subkey of a seed into the round keys of the
+// second feistel network.
+func expandSecondKey(subkey []uint32) []uint32 {
+	key2 := make([]uint32, 4)
+	expandKey(1, &key2, subkey)
+
+	key2[0] ^= bit32(key2[0], 0) << 5
+	key2[0] ^= bit32(key2[0], 6) << 11
+	key2[1] ^= bit32(key2[1], 0) << 5
+	key2[1] ^= bit32(key2[1], 1) << 4
+	key2[2] ^= bit32(key2[2], 2) << 5
+	key2[2] ^= bit32(key2[2], 3) << 4
+	key2[2] ^= bit32(key2[2], 7) << 11
+	key2[3] ^= bit32(key2[3], 1) << 5
+	return key2
+}
+
+func referenceCryptWord(direction Direction, word uint16, key2 [4]uint32) uint16 {
+	if direction == Decrypt {
+		return feistel(word, fn2_groupA, fn2_groupB,
+			optimizedSBoxes[4], optimizedSBoxes[5], optimizedSBoxes[6], optimizedSBoxes[7],
+			key2[0], key2[1], key2[2], key2[3])
+	}
+	return feistel(word, fn2_groupA, fn2_groupB,
+		optimizedSBoxes[7], optimizedSBoxes[6], optimizedSBoxes[5], optimizedSBoxes[4],
+		key2[3], key2[2], key2[1], key2[0])
+}
+
+// subkey returns the second network's round keys for word index i.
+func (c *Cipher) subkey(i int) [4]uint32 {
+	if c.reference {
+		return c.referenceKey2(i)
+	}
+	return c.fastKey2(i)
+}
+
+func (c *Cipher) cryptWord(direction Direction, word uint16, key2 [4]uint32) uint16 {
+	if c.reference {
+		return referenceCryptWord(direction, word, key2)
+	}
+	return fastCryptWord(direction, word, key2)
+}
+
+func (c *Cipher) isEncrypted(a int) bool {
+	return int64(a) >= c.Key.LowerLimit/2 && int64(a) <= c.Key.UpperLimit/2
+}
+
+func (c *Cipher) workers() int {
+	if c.Workers > 0 {
+		return c.Workers
+	}
+	return runtime.NumCPU()
+}
+
+// Each seed only touches the words at a stride of 0x10000 from it, so the
+// seeds are split into contiguous chunks that never share a word. base is the
+// word address of rom[0]; only the seeds that rom actually covers are derived.
+func (c *Cipher) crypt(direction Direction, rom []uint16, base int) []uint16 {
+	length := len(rom)
+	dec := make([]uint16, length)
+	seeds := min(length, 0x10000)
+	workers := c.workers()
+	chunkSize := (seeds + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < seeds; start += chunkSize {
+		end := min(start+chunkSize, seeds)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				key2 := c.subkey(base + i)
+				for a := i; a < length; a += 0x10000 {
+					if c.isEncrypted(base + a) {
+						dec[a] = c.cryptWord(direction, rom[a], key2)
+					} else {
+						dec[a] = rom[a]
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return dec
+}
+
+// Decrypt takes a big endian maincpu image and returns its decrypted opcodes,
+// also big endian.
+func (c *Cipher) Decrypt(romBinary []uint8) []uint8 {
+	return createUint8ArrayFromUint16Array(c.crypt(Decrypt, createUint16ArrayFromUint8Array(romBinary), 0), Decrypt)
+}
+
+// Encrypt takes a big endian decrypted image and returns the encrypted words
+// byte swapped, i.e. in the order they are stored in the MAME ROM files.
+func (c *Cipher) Encrypt(romBinary []uint8) []uint8 {
+	return createUint8ArrayFromUint16Array(c.crypt(Encrypt, createUint16ArrayFromUint8Array(romBinary), 0), Encrypt)
+}
+
+// DecryptWords decrypts words that start at the even byte address start.
+func (c *Cipher) DecryptWords(words []uint16, start int) []uint16 {
+	return c.crypt(Decrypt, words, start/2)
+}
+
+// EncryptWords encrypts words that start at the even byte address start.
+func (c *Cipher) EncryptWords(words []uint16, start int) []uint16 {
+	return c.crypt(Encrypt, words, start/2)
+}
+
+// CryptRange decrypts or encrypts the words of a big endian maincpu image from
+// byte address start up to, but not including, end. The result is big endian.
+func (c *Cipher) CryptRange(direction Direction, romBinary []uint8, start int, end int) ([]uint8, error) {
+	if start%2 != 0 || end%2 != 0 || start < 0 || start >= end || end > len(romBinary) {
+		return nil, fmt.Errorf("invalid address range 0x%06x-0x%06x", start, end)
+	}
+	words := createUint16ArrayFromUint8Array(romBinary[start:end])
+	return createUint8ArrayFromUint16Array(c.crypt(direction, words, start/2), Decrypt), nil
+}
+
+func (c *Cipher) DecryptWord(address int, word uint16) uint16 {
+	return c.cryptSingleWord(Decrypt, address, word)
+}
+
+func (c *Cipher) EncryptWord(address int, word uint16) uint16 {
+	return c.cryptSingleWord(Encrypt, address, word)
+}
+
+func (c *Cipher) cryptSingleWord(direction Direction, address int, word uint16) uint16 {
+	if !c.isEncrypted(address / 2) {
+		return word
+	}
+	return c.cryptWord(direction, word, c.subkey(address/2))
+}
@@ -0,0 +1,140 @@
+package cps2crypt
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"testing"
+)
+
+// testKeys are synthetic keys, not those of any board.
+var testKeys = []struct {
+	name       string
+	masterKey  [2]uint32
+	upperLimit int64
+}{
+	{"small limit", [2]uint32{0x12345678, 0x9abcdef0}, 0x8000},
+	{"mid limit", [2]uint32{0x0badf00d, 0xdeadbeef}, 0x23c000},
+	{"full", [2]uint32{0xffffffff, 0x00000001}, 0x1000000},
+}
+
+func testKey(t *testing.T, masterKey [2]uint32, upperLimit int64) *Key {
+	t.Helper()
+	key, err := NewKeyFromMasterKeys(masterKey, upperLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func randomImage(seed uint64, size int) []uint8 {
+	r := rand.New(rand.NewPCG(seed, seed))
+	image := make([]uint8, size)
+	for i := range image {
+		image[i] = uint8(r.Uint32())
+	}
+	return image
+}
+
+// swapBytes turns Encrypt's byte swapped output big endian.
+func swapBytes(image []uint8) []uint8 {
+	swapped := make([]uint8, len(image))
+	for i := 0; i+1 < len(image); i += 2 {
+		swapped[i], swapped[i+1] = image[i+1], image[i]
+	}
+	return swapped
+}
+
+func TestCipherMatchesReference(t *testing.T) {
+	for _, tt := range testKeys {
+		t.Run(tt.name, func(t *testing.T) {
+			key := testKey(t, tt.masterKey, tt.upperLimit)
+			fast, reference := NewCipher(key), NewReferenceCipher(key)
+			words := createUint16ArrayFromUint8Array(randomImage(1, 0x800))
+			for _, start := range []int{0, 0x7ffe, 0x1fffe, 0x123456} {
+				for _, direction := range []Direction{Decrypt, Encrypt} {
+					want := reference.crypt(direction, words, start/2)
+					got := fast.crypt(direction, words, start/2)
+					for a := range want {
+						if got[a] != want[a] {
+							t.Fatalf("direction %v from 0x%06x: word 0x%06x is 0x%04x, reference gives 0x%04x", direction, start, start+a*2, got[a], want[a])
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCipherRoundTrip(t *testing.T) {
+	for _, tt := range testKeys {
+		t.Run(tt.name, func(t *testing.T) {
+			cipher := NewCipher(testKey(t, tt.masterKey, tt.upperLimit))
+			decrypted := randomImage(2, 0x40000)
+			encrypted := swapBytes(cipher.Encrypt(decrypted))
+			if err := CompareImages(decrypted, cipher.Decrypt(encrypted), 0); err != nil {
+				t.Errorf("Decrypt(Encrypt(x)): %v", err)
+			}
+			if err := cipher.VerifyRoundTrip(Encrypt, decrypted, encrypted, 0); err != nil {
+				t.Errorf("VerifyRoundTrip: %v", err)
+			}
+			words := createUint16ArrayFromUint8Array(decrypted)
+			got := cipher.DecryptWords(cipher.EncryptWords(words, 0x2468), 0x2468)
+			for a := range words {
+				if got[a] != words[a] {
+					t.Fatalf("DecryptWords(EncryptWords(x)): word 0x%06x is 0x%04x, expected 0x%04x", 0x2468+a*2, got[a], words[a])
+				}
+			}
+		})
+	}
+}
+
+func TestCryptRange(t *testing.T) {
+	cipher := NewCipher(testKey(t, testKeys[1].masterKey, 0x20000))
+	image := randomImage(3, 0x40000)
+	decrypted := cipher.Decrypt(image)
+	tests := []struct {
+		name       string
+		start, end int
+	}{
+		{"whole image", 0, 0x40000},
+		{"unaligned start", 0x1236, 0x40000},
+		{"unaligned both", 0x1ff2, 0x2346a},
+		{"across the upper limit", 0x1fffc, 0x20006},
+		{"single word", 0x3fffe, 0x40000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cipher.CryptRange(Decrypt, image, tt.start, tt.end)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := CompareImages(decrypted[tt.start:tt.end], got, tt.start); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	for _, r := range [][2]int{{1, 0x100}, {0, 0x101}, {0x100, 0x100}, {0, 0x40002}, {-2, 0x100}} {
+		if _, err := cipher.CryptRange(Decrypt, image, r[0], r[1]); err == nil {
+			t.Errorf("CryptRange(0x%x, 0x%x) didn't fail", r[0], r[1])
+		}
+	}
+}
+
+func TestCryptWord(t *testing.T) {
+	cipher := NewCipher(testKey(t, testKeys[0].masterKey, testKeys[0].upperLimit))
+	image := randomImage(4, 0x10000)
+	decrypted := cipher.Decrypt(image)
+	for _, address := range []int{0, 0x1234, 0x8000, 0x8002, 0xfffe} {
+		word := uint16(image[address])<<8 | uint16(image[address+1])
+		want := uint16(decrypted[address])<<8 | uint16(decrypted[address+1])
+		if got := cipher.DecryptWord(address, word); got != want {
+			t.Errorf("DecryptWord(0x%06x) is 0x%04x, expected 0x%04x", address, got, want)
+		}
+		if got := cipher.EncryptWord(address, want); got != word {
+			t.Errorf("EncryptWord(0x%06x) is 0x%04x, expected 0x%04x", address, got, word)
+		}
+	}
+	if !bytes.Equal(decrypted[0x8002:], image[0x8002:]) {
+		t.Error("words past the upper limit were decrypted")
+	}
+}
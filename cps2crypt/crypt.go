@@ -1,9 +1,7 @@
 package cps2crypt
 
 import (
-	"archive/zip"
 	"fmt"
-	"io"
 
 	"github.com/MBDesu/mbdcps2/Resources"
 	"github.com/MBDesu/mbdcps2/cps2rom"
@@ -480,69 +478,25 @@ func optimizeSBoxes(out []optimized_sbox, input []sbox) {
 	}
 }
 
-func initializeOptimizedSBoxes(sboxes []optimized_sbox) {
-	for i := range 4 {
-		sboxes[i] = optimized_sbox{}
+// The optimized s-boxes only depend on the tables above, so they are built
+// once and shared read-only between every Cipher.
+var optimizedSBoxes = func() [8][]optimized_sbox {
+	boxes := [8][]sbox{
+		fn1_r1_boxes, fn1_r2_boxes, fn1_r3_boxes, fn1_r4_boxes,
+		fn2_r1_boxes, fn2_r2_boxes, fn2_r3_boxes, fn2_r4_boxes,
 	}
-}
-
-func decodeKey() {
-	decoded := [10]uint16{0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
-	for b := range 160 {
-		bit := (317 - b) % 160
-		if (key[bit/8] >> ((bit ^ 7) % 8) & 1) > 0 {
-			decoded[b/16] |= (0x8000 >> (b % 16))
-		}
-	}
-	masterKey_1 = (uint32(decoded[0]) << 16) | uint32(decoded[1])
-	masterKey_2 = (uint32(decoded[2]) << 16) | uint32(decoded[3])
-	upperLimit = 0xffffff
-	lowerLimit = 0xff0000
-	if decoded[9] != 0xffff {
-		upperLimit = ((((int64(^decoded[9])) & 0x3ff) << 14) | 0x3fff) + 1
-		lowerLimit = 0
-		Resources.Logger.Info(fmt.Sprintf("Master key 1 = 0x%08x", masterKey_1))
-		Resources.Logger.Info(fmt.Sprintf("Master key 2 = 0x%08x", masterKey_2))
-		Resources.Logger.Info(fmt.Sprintf("Lower limit = 0x%06x", lowerLimit))
-		Resources.Logger.Info(fmt.Sprintf("Upper limit = 0x%06x", upperLimit))
-	}
-	upperLimit /= 2
-	lowerLimit /= 2
-}
-
-func parseKey(romDef cps2rom.RomDefinition, romZip *zip.ReadCloser) error {
-	keyFilename := romDef.Key.Operations[0].Filename
-	var keyFile zip.File
-	for _, file := range romZip.File {
-		if file.Name == keyFilename {
-			keyFile = *file
-		}
-	}
-	if keyFile.Name != "" {
-		Resources.Logger.Info(fmt.Sprintf("key found: %s (0x%01x bytes)\n", keyFile.Name, keyFile.UncompressedSize64))
-	}
-	r, err := keyFile.Open()
-	if err != nil {
-		return err
-	}
-	defer r.Close()
-	p, err := io.ReadAll(r)
-	if err != nil {
-		return err
+	var optimized [8][]optimized_sbox
+	for i, box := range boxes {
+		optimized[i] = make([]optimized_sbox, 4)
+		optimizeSBoxes(optimized[i], box)
 	}
-	keyBytes := make([]uint8, keyFile.UncompressedSize64, 0x14)
-	for b := range len(p) {
-		keyBytes[b] = uint8(p[b])
-	}
-	key = keyBytes
-	decodeKey()
-	return nil
-}
+	return optimized
+}()
 
-func createUint8ArrayFromUint16Array(arr []uint16) []uint8 {
+func createUint8ArrayFromUint16Array(arr []uint16, direction Direction) []uint8 {
 	newArr := make([]uint8, len(arr)*2)
 	for i := 0; i < len(arr); i++ {
-		if encDir == Decrypt {
+		if direction == Decrypt {
 			val := uint8((arr[i] & 0xff00) >> 8)
 			newArr[i*2] = val
 			val = uint8(arr[i] & 0xff)
@@ -573,93 +527,39 @@ func createUint16ArrayFromUint8Array(arr []uint8) []uint16 {
 	return newArr
 }
 
-var key []uint8
-var lowerLimit int64 = 0xffffff
-var masterKey_1 uint32 = 0x0
-var masterKey_2 uint32 = 0x0
-var rom []uint16
-var upperLimit int64 = 0xff0000
-var encDir Direction
-
-func Crypt(direction Direction, romDef cps2rom.RomDefinition, romZip *zip.ReadCloser, romBinary []uint8) ([]uint8, error) {
-	encDir = direction
-	err := parseKey(romDef, romZip)
+func logKey(key *Key) {
+	if !key.IsNoEncryption() {
+		Resources.Logger.Info(fmt.Sprintf("Master key 1 = 0x%08x", key.MasterKey[0]))
+		Resources.Logger.Info(fmt.Sprintf("Master key 2 = 0x%08x", key.MasterKey[1]))
+		Resources.Logger.Info(fmt.Sprintf("Lower limit = 0x%06x", key.LowerLimit))
+		Resources.Logger.Info(fmt.Sprintf("Upper limit = 0x%06x", key.UpperLimit))
+	}
+}
+
+// LoadCipher reads and decodes the key of romDef from romZip.
+func LoadCipher(romDef cps2rom.RomDefinition, romZip *cps2rom.RomSource, workers int) (*Cipher, error) {
+	keyBytes, err := ReadKeyFromZip(romDef, romZip)
 	if err != nil {
 		return nil, err
 	}
-	rom = createUint16ArrayFromUint8Array(romBinary)
-
-	key1 := make([]uint32, 4)
-	dec := make([]uint16, len(rom))
-	sboxes10 := make([]optimized_sbox, 4)
-	sboxes11 := make([]optimized_sbox, 4)
-	sboxes12 := make([]optimized_sbox, 4)
-	sboxes13 := make([]optimized_sbox, 4)
-	sboxes20 := make([]optimized_sbox, 4)
-	sboxes21 := make([]optimized_sbox, 4)
-	sboxes22 := make([]optimized_sbox, 4)
-	sboxes23 := make([]optimized_sbox, 4)
-	sboxes := [][]optimized_sbox{
-		sboxes10, sboxes11, sboxes12, sboxes13,
-		sboxes20, sboxes21, sboxes22, sboxes23,
-	}
-	for _, sbox_set := range sboxes {
-		initializeOptimizedSBoxes(sbox_set)
+	Resources.Logger.Info(fmt.Sprintf("key found: %s (0x%01x bytes)\n", romDef.Key.Operations[0].Filename, len(keyBytes)))
+	key, err := NewKey(keyBytes)
+	if err != nil {
+		return nil, err
 	}
-	length := len(rom)
-	optimizeSBoxes(sboxes10, fn1_r1_boxes)
-	optimizeSBoxes(sboxes11, fn1_r2_boxes)
-	optimizeSBoxes(sboxes12, fn1_r3_boxes)
-	optimizeSBoxes(sboxes13, fn1_r4_boxes)
-	optimizeSBoxes(sboxes20, fn2_r1_boxes)
-	optimizeSBoxes(sboxes21, fn2_r2_boxes)
-	optimizeSBoxes(sboxes22, fn2_r3_boxes)
-	optimizeSBoxes(sboxes23, fn2_r4_boxes)
-	masterKey := []uint32{masterKey_1, masterKey_2}
-	expandKey(0, &key1, masterKey)
-
-	key1[0] ^= bit32(key1[0], 1) << 4
-	key1[0] ^= bit32(key1[0], 2) << 5
-	key1[0] ^= bit32(key1[0], 8) << 11
-	key1[1] ^= bit32(key1[1], 0) << 5
-	key1[1] ^= bit32(key1[1], 8) << 11
-	key1[2] ^= bit32(key1[2], 1) << 5
-	key1[2] ^= bit32(key1[2], 8) << 11
-
-	for i := 0; i <= 0xffff; i++ {
-		subkey := make([]uint32, 2)
-		key2 := make([]uint32, 4)
-
-		seed := feistel(uint16(i&0xffff), fn1_groupA, fn1_groupB, sboxes10, sboxes11, sboxes12, sboxes13, key1[0], key1[1], key1[2], key1[3])
-		// fmt.Printf("seed = %04x\n", seed)
-		expandSubkey(&subkey, seed)
-
-		subkey[0] ^= masterKey_1
-		subkey[1] ^= masterKey_2
-
-		expandKey(1, &key2, subkey)
-
-		key2[0] ^= bit32(key2[0], 0) << 5
-		key2[0] ^= bit32(key2[0], 6) << 11
-		key2[1] ^= bit32(key2[1], 0) << 5
-		key2[1] ^= bit32(key2[1], 1) << 4
-		key2[2] ^= bit32(key2[2], 2) << 5
-		key2[2] ^= bit32(key2[2], 3) << 4
-		key2[2] ^= bit32(key2[2], 7) << 11
-		key2[3] ^= bit32(key2[3], 1) << 5
-
-		for a := i; a < length; a += 0x10000 {
-			if int64(a) >= lowerLimit && int64(a) <= upperLimit {
-				if direction { // decrypt
-					dec[a] = feistel(rom[a], fn2_groupA, fn2_groupB, sboxes20, sboxes21, sboxes22, sboxes23, key2[0], key2[1], key2[2], key2[3])
-				} else { // encrypt
-					dec[a] = feistel(rom[a], fn2_groupA, fn2_groupB, sboxes23, sboxes22, sboxes21, sboxes20, key2[3], key2[2], key2[1], key2[0])
-				}
-			} else {
-				dec[a] = rom[a]
-			}
-		}
+	logKey(key)
+	cipher := NewCipher(key)
+	cipher.Workers = workers
+	return cipher, nil
+}
 
+func Crypt(direction Direction, romDef cps2rom.RomDefinition, romZip *cps2rom.RomSource, romBinary []uint8, workers int) ([]uint8, error) {
+	cipher, err := LoadCipher(romDef, romZip, workers)
+	if err != nil {
+		return nil, err
+	}
+	if direction == Decrypt {
+		return cipher.Decrypt(romBinary), nil
 	}
-	return createUint8ArrayFromUint16Array(dec), nil
+	return cipher.Encrypt(romBinary), nil
 }
@@ -0,0 +1,140 @@
+package cps2crypt
+
+// The table driven feistel networks. They give the same results as feistel and
+// the key schedule around it, which are kept as the reference implementation,
+// but replace the bit by bit work with lookups:
+//
+//   - splitting a word into its halves and joining them back is a byte wise
+//     permutation, so each takes two lookups
+//   - each s-box is tabled for every key and input byte, saving the input
+//     lookup
+//   - the first network only ever runs with the key's first round keys, so its
+//     rounds are tabled once per key
+//   - the subkey of a seed and the second round keys made from it are linear
+//     in the seed and the master keys, so the round keys of a seed are two
+//     lookups XORed with a constant made once per key
+
+type feistelPermutation struct {
+	// l in the high byte, r in the low byte
+	splitLow  [256]uint16
+	splitHigh [256]uint16
+	joinLeft  [256]uint16
+	joinRight [256]uint16
+}
+
+func newFeistelPermutation(bitsA []int, bitsB []int) *feistelPermutation {
+	var p feistelPermutation
+	for v := range 256 {
+		for _, half := range []struct {
+			table *[256]uint16
+			val   uint16
+		}{{&p.splitLow, uint16(v)}, {&p.splitHigh, uint16(v) << 8}} {
+			l := bitswap8(half.val, bitsB[7], bitsB[6], bitsB[5], bitsB[4], bitsB[3], bitsB[2], bitsB[1], bitsB[0])
+			r := bitswap8(half.val, bitsA[7], bitsA[6], bitsA[5], bitsA[4], bitsA[3], bitsA[2], bitsA[1], bitsA[0])
+			half.table[v] = uint16(l)<<8 | uint16(r)
+		}
+		for bit := range 8 {
+			p.joinLeft[v] |= bit8_16(uint8(v), bit) << bitsA[bit]
+			p.joinRight[v] |= bit8_16(uint8(v), bit) << bitsB[bit]
+		}
+	}
+	return &p
+}
+
+func (p *feistelPermutation) split(val uint16) (uint8, uint8) {
+	halves := p.splitLow[val&0xff] | p.splitHigh[val>>8]
+	return uint8(halves >> 8), uint8(halves)
+}
+
+func (p *feistelPermutation) join(l uint8, r uint8) uint16 {
+	return p.joinLeft[l] | p.joinRight[r]
+}
+
+var fn1Permutation = newFeistelPermutation(fn1_groupA, fn1_groupB)
+var fn2Permutation = newFeistelPermutation(fn2_groupA, fn2_groupB)
+
+// fn2Tables holds the output of every s-box of the second network for every
+// key and input byte.
+var fn2Tables = func() *[4][4][64][256]uint8 {
+	var tables [4][4][64][256]uint8
+	for round := range 4 {
+		for box := range 4 {
+			sbox := &optimizedSBoxes[4+round][box]
+			for key := range 64 {
+				for in := range 256 {
+					tables[round][box][key][in] = sbox.output[sbox.input_lookup[in]^uint8(key)]
+				}
+			}
+		}
+	}
+	return &tables
+}()
+
+// seedKeys holds the second round keys of the low and high byte of a seed,
+// with zeroed master keys.
+var seedKeys = func() *[2][256][4]uint32 {
+	var keys [2][256][4]uint32
+	subkey := make([]uint32, 2)
+	for b := range 2 {
+		for v := range 256 {
+			expandSubkey(&subkey, uint16(v)<<(8*b))
+			copy(keys[b][v][:], expandSecondKey(subkey))
+		}
+	}
+	return &keys
+}()
+
+func fn2(round int, in uint8, key uint32) uint8 {
+	tables := &fn2Tables[round]
+	return tables[0][key&0x3f][in] |
+		tables[1][key>>6&0x3f][in] |
+		tables[2][key>>12&0x3f][in] |
+		tables[3][key>>18&0x3f][in]
+}
+
+// firstRounds tables the first network's round functions for key1.
+func firstRounds(key1 [4]uint32) [4][256]uint8 {
+	var rounds [4][256]uint8
+	for round := range 4 {
+		for in := range 256 {
+			rounds[round][in] = fn(uint8(in), optimizedSBoxes[round], key1[round])
+		}
+	}
+	return rounds
+}
+
+func (c *Cipher) fastSeed(i int) uint16 {
+	l, r := fn1Permutation.split(uint16(i & 0xffff))
+	l ^= c.rounds[0][r]
+	r ^= c.rounds[1][l]
+	l ^= c.rounds[2][r]
+	r ^= c.rounds[3][l]
+	return fn1Permutation.join(l, r)
+}
+
+func (c *Cipher) fastKey2(i int) [4]uint32 {
+	seed := c.fastSeed(i)
+	low, high := &seedKeys[0][seed&0xff], &seedKeys[1][seed>>8]
+	return [4]uint32{
+		c.key2[0] ^ low[0] ^ high[0],
+		c.key2[1] ^ low[1] ^ high[1],
+		c.key2[2] ^ low[2] ^ high[2],
+		c.key2[3] ^ low[3] ^ high[3],
+	}
+}
+
+func fastCryptWord(direction Direction, word uint16, key2 [4]uint32) uint16 {
+	l, r := fn2Permutation.split(word)
+	if direction == Decrypt {
+		l ^= fn2(0, r, key2[0])
+		r ^= fn2(1, l, key2[1])
+		l ^= fn2(2, r, key2[2])
+		r ^= fn2(3, l, key2[3])
+	} else {
+		l ^= fn2(3, r, key2[3])
+		r ^= fn2(2, l, key2[2])
+		l ^= fn2(1, r, key2[1])
+		r ^= fn2(0, l, key2[0])
+	}
+	return fn2Permutation.join(l, r)
+}
@@ -0,0 +1,190 @@
+package cps2crypt
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/MBDesu/mbdcps2/cps2rom"
+)
+
+const KeyLength = 0x14
+
+// Key files with this value in the limit word only encrypt 0xff0000-0xffffff,
+// which is past the end of every maincpu region.
+const noEncryptionLimitWord = 0xffff
+
+// Key is a decoded CPS2 key file. The limits are byte addresses into the
+// maincpu region; words between them (inclusive) are encrypted.
+//
+// Besides the master keys and the limit a key file holds the watchdog
+// instruction (words 4-6) and two constants (words 7 and 8), which are kept as
+// they are when a Key is encoded again.
+type Key struct {
+	MasterKey  [2]uint32
+	LowerLimit int64
+	UpperLimit int64
+	decoded    [10]uint16
+}
+
+func decodeKey(keyBytes []uint8) [10]uint16 {
+	decoded := [10]uint16{0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	for b := range 160 {
+		bit := (317 - b) % 160
+		if (keyBytes[bit/8] >> ((bit ^ 7) % 8) & 1) > 0 {
+			decoded[b/16] |= (0x8000 >> (b % 16))
+		}
+	}
+	return decoded
+}
+
+func encodeKey(decoded [10]uint16) []uint8 {
+	keyBytes := make([]uint8, KeyLength)
+	for b := range 160 {
+		bit := (317 - b) % 160
+		if decoded[b/16]&(0x8000>>(b%16)) > 0 {
+			keyBytes[bit/8] |= 1 << ((bit ^ 7) % 8)
+		}
+	}
+	return keyBytes
+}
+
+func NewKey(keyBytes []uint8) (*Key, error) {
+	if len(keyBytes) < KeyLength {
+		return nil, fmt.Errorf("key is 0x%02x bytes, expected 0x%02x", len(keyBytes), KeyLength)
+	}
+	decoded := decodeKey(keyBytes)
+	k := Key{decoded: decoded}
+	k.MasterKey[0] = (uint32(decoded[0]) << 16) | uint32(decoded[1])
+	k.MasterKey[1] = (uint32(decoded[2]) << 16) | uint32(decoded[3])
+	k.LowerLimit, k.UpperLimit = limitsFromWord(decoded[9])
+	return &k, nil
+}
+
+func limitsFromWord(limitWord uint16) (int64, int64) {
+	if limitWord == noEncryptionLimitWord {
+		return 0xff0000, 0xffffff
+	}
+	return 0, ((((int64(^limitWord)) & 0x3ff) << 14) | 0x3fff) + 1
+}
+
+// NewKeyFromMasterKeys builds a key that encrypts everything below
+// upperLimit. The watchdog instruction is left zeroed.
+func NewKeyFromMasterKeys(masterKey [2]uint32, upperLimit int64) (*Key, error) {
+	k := Key{MasterKey: masterKey, LowerLimit: 0, UpperLimit: upperLimit}
+	k.decoded[7] = 0x4000
+	k.decoded[8] = 0x0900
+	limitWord, err := k.limitWord()
+	if err != nil {
+		return nil, err
+	}
+	k.decoded[9] = limitWord
+	return &k, nil
+}
+
+// NewNoEncryptionKey builds the null key used by phoenix sets, which leaves
+// all of maincpu unencrypted.
+func NewNoEncryptionKey() *Key {
+	k := Key{LowerLimit: 0xff0000, UpperLimit: 0xffffff}
+	k.decoded[7] = 0x4000
+	k.decoded[8] = 0x0900
+	k.decoded[9] = noEncryptionLimitWord
+	return &k
+}
+
+func (k *Key) limitWord() (uint16, error) {
+	// keep the original word if the limits haven't changed, its top bits are
+	// ignored but don't have to be set
+	lowerLimit, upperLimit := limitsFromWord(k.decoded[9])
+	if k.LowerLimit == lowerLimit && k.UpperLimit == upperLimit {
+		return k.decoded[9], nil
+	}
+	if k.IsNoEncryption() {
+		return noEncryptionLimitWord, nil
+	}
+	if k.LowerLimit != 0 {
+		return 0, fmt.Errorf("lower limit must be 0x000000, got 0x%06x", k.LowerLimit)
+	}
+	// an upper limit of 0x4000 would encode as 0xffff, the no encryption value
+	if k.UpperLimit%0x4000 != 0 || k.UpperLimit <= 0x4000 || k.UpperLimit > 0x1000000 {
+		return 0, fmt.Errorf("upper limit must be a multiple of 0x4000 between 0x008000 and 0x1000000, got 0x%06x", k.UpperLimit)
+	}
+	return ^uint16(k.UpperLimit/0x4000 - 1), nil
+}
+
+// Encode returns the key file for k.
+func (k *Key) Encode() ([]uint8, error) {
+	limitWord, err := k.limitWord()
+	if err != nil {
+		return nil, err
+	}
+	decoded := k.decoded
+	decoded[0] = uint16(k.MasterKey[0] >> 16)
+	decoded[1] = uint16(k.MasterKey[0])
+	decoded[2] = uint16(k.MasterKey[1] >> 16)
+	decoded[3] = uint16(k.MasterKey[1])
+	decoded[9] = limitWord
+	return encodeKey(decoded), nil
+}
+
+// IsNoEncryption reports whether the key leaves the whole maincpu region
+// unencrypted, like the keys of dead or phoenixed boards.
+func (k *Key) IsNoEncryption() bool {
+	return k.LowerLimit == 0xff0000 && k.UpperLimit == 0xffffff
+}
+
+type KeyInfo struct {
+	MasterKey1   string `json:"masterKey1"`
+	MasterKey2   string `json:"masterKey2"`
+	LowerLimit   string `json:"lowerLimit"`
+	UpperLimit   string `json:"upperLimit"`
+	NoEncryption bool   `json:"noEncryption"`
+	Consistent   bool   `json:"consistent"`
+	Problem      string `json:"problem,omitempty"`
+}
+
+func (k *Key) Info() KeyInfo {
+	info := KeyInfo{
+		MasterKey1:   fmt.Sprintf("0x%08x", k.MasterKey[0]),
+		MasterKey2:   fmt.Sprintf("0x%08x", k.MasterKey[1]),
+		LowerLimit:   fmt.Sprintf("0x%06x", k.LowerLimit),
+		UpperLimit:   fmt.Sprintf("0x%06x", k.UpperLimit),
+		NoEncryption: k.IsNoEncryption(),
+		Consistent:   true,
+	}
+	if err := k.Validate(); err != nil {
+		info.Consistent = false
+		info.Problem = err.Error()
+	}
+	return info
+}
+
+// Validate checks the parts of the key that are the same on every board.
+func (k *Key) Validate() error {
+	var problems []string
+	if k.decoded[7] != 0x4000 {
+		problems = append(problems, fmt.Sprintf("word 7 is 0x%04x, expected 0x4000", k.decoded[7]))
+	}
+	if k.decoded[8] != 0x0900 {
+		problems = append(problems, fmt.Sprintf("word 8 is 0x%04x, expected 0x0900", k.decoded[8]))
+	}
+	if _, err := k.limitWord(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("key is inconsistent: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// ReadKeyFromZip reads the key of romDef from romZip, a .zip or directory.
+func ReadKeyFromZip(romDef cps2rom.RomDefinition, romZip *cps2rom.RomSource) ([]uint8, error) {
+	if len(romDef.Key.Operations) == 0 {
+		return nil, fmt.Errorf("ROM set has no key")
+	}
+	keyFilename := romDef.Key.Operations[0].Filename
+	keyFile := romZip.Find(keyFilename)
+	if keyFile == nil {
+		return nil, fmt.Errorf("key %s not found", keyFilename)
+	}
+	return keyFile.ReadAll()
+}
@@ -0,0 +1,64 @@
+package cps2crypt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestKeyEncodeRoundTrip(t *testing.T) {
+	for _, tt := range testKeys {
+		t.Run(tt.name, func(t *testing.T) {
+			key := testKey(t, tt.masterKey, tt.upperLimit)
+			keyBytes, err := key.Encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keyBytes) != KeyLength {
+				t.Fatalf("key file is 0x%02x bytes, expected 0x%02x", len(keyBytes), KeyLength)
+			}
+			decoded, err := NewKey(keyBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded.MasterKey != tt.masterKey || decoded.LowerLimit != 0 || decoded.UpperLimit != tt.upperLimit {
+				t.Errorf("decoded master keys 0x%08x, limits 0x%06x-0x%06x, expected 0x%08x, 0x000000-0x%06x", decoded.MasterKey, decoded.LowerLimit, decoded.UpperLimit, tt.masterKey, tt.upperLimit)
+			}
+			if err := decoded.Validate(); err != nil {
+				t.Error(err)
+			}
+			reencoded, err := decoded.Encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(reencoded, keyBytes) {
+				t.Errorf("encoding the decoded key gives % x, expected % x", reencoded, keyBytes)
+			}
+		})
+	}
+}
+
+func TestNoEncryptionKey(t *testing.T) {
+	keyBytes, err := NewNoEncryptionKey().Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := NewKey(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.IsNoEncryption() {
+		t.Errorf("limits are 0x%06x-0x%06x, expected no encryption", key.LowerLimit, key.UpperLimit)
+	}
+	image := randomImage(5, 0x1000)
+	if !bytes.Equal(NewCipher(key).Decrypt(image), image) {
+		t.Error("the no encryption key changed maincpu")
+	}
+}
+
+func TestKeyLimits(t *testing.T) {
+	for _, upperLimit := range []int64{0, 0x4000, 0x9000, 0x1004000} {
+		if _, err := NewKeyFromMasterKeys([2]uint32{1, 2}, upperLimit); err == nil {
+			t.Errorf("upper limit 0x%06x didn't fail", upperLimit)
+		}
+	}
+}
@@ -0,0 +1,491 @@
+package cps2crypt
+
+import (
+	"fmt"
+	"math/bits"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Key recovery works on the second feistel network of a single seed. Every
+// word whose index is congruent to i modulo 0x10000 shares the 64 bit subkey
+// of seed i, so known encrypted/decrypted pairs at those indices pin the subkey
+// down one s-box at a time. The master keys then follow from trying all 0x10000
+// seeds against the subkey.
+
+// The least number of distinct known pairs a seed needs before its subkey is
+// searched for. Each inner s-box checks 2 bits of every pair, with fewer pairs
+// too many subkeys get past the first checks for the search to finish. Pairs
+// of different seeds can't be pooled, their subkeys differ.
+const minRecoveryPairs = 12
+
+// minRecoverySpan is how many bytes the known encrypted words must span for a
+// seed to have minRecoveryPairs of them, as a seed's words are 0x20000 bytes
+// apart.
+const minRecoverySpan = (minRecoveryPairs-1)*0x20000 + 2
+
+// Searches that find more subkeys than this give up, the known words don't
+// pin the subkey down.
+const maxRecoverySubkeys = 64
+
+type recoveryUnit struct {
+	id         int
+	round      int
+	sbox       *optimized_sbox
+	keyShift   int
+	subkeyBits []int
+	// keys maps the subkey bits (bit j is subkeyBits[j]) to the s-box key
+	keys       []uint8
+	inputMask  uint8
+	outputMask uint8
+}
+
+type recoveryPair struct {
+	l0, r0, l2, r2 uint8
+}
+
+type recoveryStep struct {
+	unit *recoveryUnit
+	// free holds the positions in unit.subkeyBits that earlier steps haven't
+	// fixed
+	free []int
+	// raws and subkeys spread each value of the free bits over the positions
+	// of unit.subkeyBits and over the subkey
+	raws       []int
+	subkeys    []uint64
+	freeMask   int
+	subkeyMask uint64
+	// the outer s-boxes giving the middle halves an inner s-box is checked on
+	l1Units []*recoveryUnit
+	r1Units []*recoveryUnit
+}
+
+// recoveryUnits finds which subkey bits make up the key of each s-box of the
+// second feistel network, by setting one subkey bit at a time.
+func recoveryUnits() []*recoveryUnit {
+	units := make([]*recoveryUnit, 16)
+	columns := make([][]uint8, 16)
+	for round := range 4 {
+		for box := range 4 {
+			sbox := &optimizedSBoxes[4+round][box]
+			unit := recoveryUnit{id: 4*round + box, round: round, sbox: sbox, keyShift: 6 * box}
+			for bit := range 8 {
+				if sbox.input_lookup[1<<bit] != sbox.input_lookup[0] {
+					unit.inputMask |= 1 << bit
+				}
+			}
+			for _, output := range sbox.output {
+				unit.outputMask |= output
+			}
+			units[4*round+box] = &unit
+		}
+	}
+	for bit := range 64 {
+		subkey := []uint32{0, 0}
+		subkey[bit/32] = 1 << (bit % 32)
+		key2 := expandSecondKey(subkey)
+		for u, unit := range units {
+			if key := uint8(key2[unit.round]>>unit.keyShift) & 0x3f; key != 0 {
+				unit.subkeyBits = append(unit.subkeyBits, bit)
+				columns[u] = append(columns[u], key)
+			}
+		}
+	}
+	// the key schedule is linear, so the key of any combination of bits is the
+	// XOR of the keys of each bit
+	for u, unit := range units {
+		unit.keys = make([]uint8, 1<<len(unit.subkeyBits))
+		for raw := range unit.keys {
+			for j, column := range columns[u] {
+				if raw>>j&1 != 0 {
+					unit.keys[raw] ^= column
+				}
+			}
+		}
+	}
+	return units
+}
+
+// recoverySteps orders the s-boxes so the inner rounds can be checked as early
+// as possible. Rounds 0 and 3 turn the known halves into the unknown middle
+// halves and can't be checked on their own; a round 1 or 2 s-box can once the
+// outer s-boxes producing its inputs and outputs are placed.
+func recoverySteps(units []*recoveryUnit) []recoveryStep {
+	var steps []recoveryStep
+	placed := make([]bool, len(units))
+	var fixed uint64
+	// the outer s-boxes an inner one depends on
+	requires := func(u int) []int {
+		unit := units[u]
+		l1Mask, r1Mask := unit.inputMask, unit.outputMask
+		if unit.round == 2 {
+			l1Mask, r1Mask = unit.outputMask, unit.inputMask
+		}
+		var required []int
+		for o, outer := range units {
+			if outer.round == 0 && outer.outputMask&l1Mask != 0 || outer.round == 3 && outer.outputMask&r1Mask != 0 {
+				required = append(required, o)
+			}
+		}
+		return required
+	}
+	place := func(u int) {
+		unit := units[u]
+		step := recoveryStep{unit: unit}
+		if unit.round == 1 || unit.round == 2 {
+			for _, o := range requires(u) {
+				if units[o].round == 0 {
+					step.l1Units = append(step.l1Units, units[o])
+				} else {
+					step.r1Units = append(step.r1Units, units[o])
+				}
+			}
+		}
+		for j, bit := range unit.subkeyBits {
+			if fixed>>bit&1 == 0 {
+				step.free = append(step.free, j)
+				fixed |= 1 << bit
+			}
+		}
+		step.raws = make([]int, 1<<len(step.free))
+		step.subkeys = make([]uint64, 1<<len(step.free))
+		for v := range step.raws {
+			for f, j := range step.free {
+				bit := v >> f & 1
+				step.raws[v] |= bit << j
+				step.subkeys[v] |= uint64(bit) << unit.subkeyBits[j]
+			}
+		}
+		for _, j := range step.free {
+			step.freeMask |= 1 << j
+			step.subkeyMask |= 1 << unit.subkeyBits[j]
+		}
+		placed[u] = true
+		steps = append(steps, step)
+	}
+	for {
+		best, bestCost := -1, 0
+		for u, unit := range units {
+			if placed[u] || unit.round == 0 || unit.round == 3 {
+				continue
+			}
+			var newBits uint64
+			for _, o := range append(requires(u), u) {
+				if !placed[o] {
+					for _, bit := range units[o].subkeyBits {
+						newBits |= 1 << bit
+					}
+				}
+			}
+			cost := bits.OnesCount64(newBits &^ fixed)
+			if best < 0 || cost < bestCost {
+				best, bestCost = u, cost
+			}
+		}
+		if best < 0 {
+			break
+		}
+		for _, o := range requires(best) {
+			if !placed[o] {
+				place(o)
+			}
+		}
+		place(best)
+	}
+	for u := range units {
+		if !placed[u] {
+			place(u)
+		}
+	}
+	return steps
+}
+
+type recoverySearch struct {
+	steps    []recoveryStep
+	pairs    []recoveryPair
+	mu       sync.Mutex
+	subkeys  []uint64
+	tooMany  atomic.Bool
+	inputs   [][]uint8
+	expected [][]uint8
+	keys     [16]uint8
+	subkey   uint64
+}
+
+func (s *recoverySearch) fork() *recoverySearch {
+	f := &recoverySearch{steps: s.steps, pairs: s.pairs}
+	f.inputs = make([][]uint8, len(s.steps))
+	f.expected = make([][]uint8, len(s.steps))
+	for i := range s.steps {
+		f.inputs[i] = make([]uint8, len(s.pairs))
+		f.expected[i] = make([]uint8, len(s.pairs))
+	}
+	return f
+}
+
+func (s *recoverySearch) found(subkey uint64, root *recoverySearch) {
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	root.subkeys = append(root.subkeys, subkey)
+	if len(root.subkeys) > maxRecoverySubkeys {
+		root.tooMany.Store(true)
+	}
+}
+
+// middleHalves returns the bits of l1 and r1 that the outer s-boxes of step
+// give pair.
+func (s *recoverySearch) middleHalves(step *recoveryStep, pair recoveryPair) (uint8, uint8) {
+	var l1, r1 uint8
+	for _, unit := range step.l1Units {
+		l1 |= (pair.l0 ^ unit.sbox.output[unit.sbox.input_lookup[pair.r0]^s.keys[unit.id]]) & unit.outputMask
+	}
+	for _, unit := range step.r1Units {
+		r1 |= (pair.r2 ^ unit.sbox.output[unit.sbox.input_lookup[pair.l2]^s.keys[unit.id]]) & unit.outputMask
+	}
+	return l1, r1
+}
+
+// search tries every value of the free bits of step depth. Rounds 0 and 3
+// only set their s-box key; rounds 1 and 2 compare their s-box output against
+// the middle halves those keys give, which are only worked out for as many
+// pairs as it takes to rule a key out.
+func (s *recoverySearch) search(depth int, from int, to int, root *recoverySearch) {
+	if depth == len(s.steps) {
+		s.found(s.subkey, root)
+		return
+	}
+	if root.tooMany.Load() {
+		return
+	}
+	step := &s.steps[depth]
+	unit := step.unit
+	var base int
+	for j, bit := range unit.subkeyBits {
+		base |= int(s.subkey>>bit&1) << j
+	}
+	base &^= step.freeMask
+	subkeyBase := s.subkey &^ step.subkeyMask
+	inputs, expected := s.inputs[depth], s.expected[depth]
+	known := 0
+	for v := from; v < to; v++ {
+		key := unit.keys[base|step.raws[v]]
+		if unit.round == 1 || unit.round == 2 {
+			isMatch := true
+			for p := range s.pairs {
+				if p == known {
+					pair := s.pairs[p]
+					l1, r1 := s.middleHalves(step, pair)
+					if unit.round == 1 {
+						inputs[p] = unit.sbox.input_lookup[l1]
+						expected[p] = (pair.r0 ^ r1) & unit.outputMask
+					} else {
+						inputs[p] = unit.sbox.input_lookup[r1]
+						expected[p] = (pair.l2 ^ l1) & unit.outputMask
+					}
+					known++
+				}
+				if unit.sbox.output[inputs[p]^key] != expected[p] {
+					isMatch = false
+					break
+				}
+			}
+			if !isMatch {
+				continue
+			}
+		}
+		s.keys[unit.id] = key
+		s.subkey = subkeyBase | step.subkeys[v]
+		s.search(depth+1, 0, 1<<len(s.steps[min(depth+1, len(s.steps)-1)].free), root)
+	}
+}
+
+// searchSubkeys returns every second network subkey that decrypts all pairs.
+func searchSubkeys(pairs []recoveryPair, workers int) ([]uint64, error) {
+	root := &recoverySearch{steps: recoverySteps(recoveryUnits()), pairs: pairs}
+	values := 1 << len(root.steps[0].free)
+	workers = max(1, min(workers, values))
+	chunkSize := (values + workers - 1) / workers
+	var wg sync.WaitGroup
+	for from := 0; from < values; from += chunkSize {
+		to := min(from+chunkSize, values)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			root.fork().search(0, from, to, root)
+		}()
+	}
+	wg.Wait()
+	if root.tooMany.Load() {
+		return nil, fmt.Errorf("more than %d subkeys fit the known words, more are needed", maxRecoverySubkeys)
+	}
+	return root.subkeys, nil
+}
+
+func newRecoveryPair(encrypted uint16, decrypted uint16) recoveryPair {
+	return recoveryPair{
+		l0: bitswap8(encrypted, fn2_groupB[7], fn2_groupB[6], fn2_groupB[5], fn2_groupB[4], fn2_groupB[3], fn2_groupB[2], fn2_groupB[1], fn2_groupB[0]),
+		r0: bitswap8(encrypted, fn2_groupA[7], fn2_groupA[6], fn2_groupA[5], fn2_groupA[4], fn2_groupA[3], fn2_groupA[2], fn2_groupA[1], fn2_groupA[0]),
+		l2: bitswap8(decrypted, fn2_groupA[7], fn2_groupA[6], fn2_groupA[5], fn2_groupA[4], fn2_groupA[3], fn2_groupA[2], fn2_groupA[1], fn2_groupA[0]),
+		r2: bitswap8(decrypted, fn2_groupB[7], fn2_groupB[6], fn2_groupB[5], fn2_groupB[4], fn2_groupB[3], fn2_groupB[2], fn2_groupB[1], fn2_groupB[0]),
+	}
+}
+
+// seedPairs returns the distinct known pairs of seed.
+func seedPairs(seed int, encrypted []uint16, decrypted []uint16) []recoveryPair {
+	seen := map[uint32]bool{}
+	var pairs []recoveryPair
+	for a := seed; a < len(encrypted); a += 0x10000 {
+		pair := uint32(encrypted[a])<<16 | uint32(decrypted[a])
+		if encrypted[a] == decrypted[a] || seen[pair] {
+			continue
+		}
+		seen[pair] = true
+		pairs = append(pairs, newRecoveryPair(encrypted[a], decrypted[a]))
+	}
+	return pairs
+}
+
+// seedsByWords returns the seeds with at least minRecoveryPairs encrypted
+// words, most words first.
+func seedsByWords(encrypted []uint16, decrypted []uint16) []int {
+	counts := make([]int, 0x10000)
+	for a := range encrypted {
+		if encrypted[a] != decrypted[a] {
+			counts[a&0xffff]++
+		}
+	}
+	var seeds []int
+	for seed, count := range counts {
+		if count >= minRecoveryPairs {
+			seeds = append(seeds, seed)
+		}
+	}
+	sort.SliceStable(seeds, func(i, j int) bool {
+		return counts[seeds[i]] > counts[seeds[j]]
+	})
+	return seeds
+}
+
+// masterKeysForSubkey returns the master keys whose first feistel network
+// gives seed the subkey found for it.
+func masterKeysForSubkey(subkey uint64, seed int) [][2]uint32 {
+	var masterKeys [][2]uint32
+	expanded := make([]uint32, 2)
+	for s := range 0x10000 {
+		expandSubkey(&expanded, uint16(s))
+		masterKey := [2]uint32{uint32(subkey) ^ expanded[0], uint32(subkey>>32) ^ expanded[1]}
+		if int(referenceSeed(firstKey(masterKey), seed)) == s {
+			masterKeys = append(masterKeys, masterKey)
+		}
+	}
+	return masterKeys
+}
+
+// matchingWords counts how many of up to 0x100 encrypted words spread over
+// the image decrypt to the known words with masterKey.
+func matchingWords(masterKey [2]uint32, encrypted []uint16, decrypted []uint16) (int, int) {
+	cipher := NewCipher(&Key{MasterKey: masterKey})
+	var addresses []int
+	for a := range encrypted {
+		if encrypted[a] != decrypted[a] {
+			addresses = append(addresses, a)
+		}
+	}
+	stride := max(1, len(addresses)/0x100)
+	matches, total := 0, 0
+	for i := 0; i < len(addresses); i += stride {
+		a := addresses[i]
+		if cipher.cryptWord(Decrypt, encrypted[a], cipher.subkey(a)) == decrypted[a] {
+			matches++
+		}
+		total++
+	}
+	return matches, total
+}
+
+// recoveredUpperLimit is the lowest upper limit that covers every encrypted
+// word.
+func recoveredUpperLimit(encrypted []uint16, decrypted []uint16) int64 {
+	last := 0
+	for a := range encrypted {
+		if encrypted[a] != decrypted[a] {
+			last = a
+		}
+	}
+	upperLimit := (int64(last)*2 + 0x3fff) / 0x4000 * 0x4000
+	return max(upperLimit, 0x8000)
+}
+
+// RecoverKey recovers the key of a maincpu image from known decrypted words.
+// Both images are big endian and start at address 0; only the words they both
+// cover are used, so decrypted may be a partial dump. The words that differ
+// between the two must be decrypted correctly, any number of the rest may be
+// unknown as long as they're left as in encrypted.
+//
+// The lower limit is always 0 and the upper limit is the lowest one that covers
+// every word that differs, so words past the end of decrypted are assumed to be
+// unencrypted.
+//
+// A seed only has one word every 0x20000 bytes, and needs minRecoveryPairs
+// distinct known ones, so keys can't be recovered from images whose known
+// encrypted words span less than minRecoverySpan (0x160002) bytes, however
+// many there are.
+func RecoverKey(encrypted []uint8, decrypted []uint8, workers int) (*Key, error) {
+	length := min(len(encrypted), len(decrypted)) / 2 * 2
+	encryptedWords := createUint16ArrayFromUint8Array(encrypted[:length])
+	decryptedWords := createUint16ArrayFromUint8Array(decrypted[:length])
+	seeds := seedsByWords(encryptedWords, decryptedWords)
+	if len(seeds) == 0 {
+		isUnencrypted := true
+		for a := range encryptedWords {
+			if encryptedWords[a] != decryptedWords[a] {
+				isUnencrypted = false
+				break
+			}
+		}
+		if isUnencrypted {
+			return NewNoEncryptionKey(), nil
+		}
+		return nil, fmt.Errorf("no seed has %d known encrypted words, they must span at least 0x%06x bytes", minRecoveryPairs, minRecoverySpan)
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	// a seed whose known words don't pin its subkey down, or that hold a
+	// patched word, is skipped for the next best one
+	lastErr := fmt.Errorf("no seed has %d distinct known encrypted words, they must span at least 0x%06x bytes", minRecoveryPairs, minRecoverySpan)
+	tries := 0
+	for _, seed := range seeds {
+		pairs := seedPairs(seed, encryptedWords, decryptedWords)
+		if len(pairs) < minRecoveryPairs {
+			continue
+		}
+		if tries++; tries > 4 {
+			break
+		}
+		subkeys, err := searchSubkeys(pairs, workers)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		var best [2]uint32
+		bestMatches, total := 0, 0
+		for _, subkey := range subkeys {
+			for _, masterKey := range masterKeysForSubkey(subkey, seed) {
+				matches, n := matchingWords(masterKey, encryptedWords, decryptedWords)
+				if matches > bestMatches {
+					best, bestMatches, total = masterKey, matches, n
+				}
+			}
+		}
+		// allow for a few words patched after decryption
+		if bestMatches == 0 || bestMatches*10 < total*9 {
+			lastErr = fmt.Errorf("no master keys decrypt the known words of seed 0x%04x", seed)
+			continue
+		}
+		return NewKeyFromMasterKeys(best, recoveredUpperLimit(encryptedWords, decryptedWords))
+	}
+	return nil, lastErr
+}
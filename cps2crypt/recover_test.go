@@ -0,0 +1,56 @@
+package cps2crypt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRecoverKey(t *testing.T) {
+	if testing.Short() {
+		t.Skip("recovering a key takes seconds")
+	}
+	tests := []struct {
+		name       string
+		masterKey  [2]uint32
+		upperLimit int64
+	}{
+		{"planted key", [2]uint32{0x0badf00d, 0xdeadbeef}, 0x180000},
+		{"another planted key", [2]uint32{0x31415926, 0x53589793}, 0x200000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := testKey(t, tt.masterKey, tt.upperLimit)
+			decrypted := randomImage(6, int(tt.upperLimit))
+			encrypted := swapBytes(NewCipher(key).Encrypt(decrypted))
+			recovered, err := RecoverKey(encrypted, decrypted, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if recovered.MasterKey != tt.masterKey || recovered.UpperLimit != tt.upperLimit {
+				t.Errorf("recovered master keys 0x%08x, upper limit 0x%06x, expected 0x%08x, 0x%06x", recovered.MasterKey, recovered.UpperLimit, tt.masterKey, tt.upperLimit)
+			}
+		})
+	}
+}
+
+func TestRecoverKeyTooFewWords(t *testing.T) {
+	key := testKey(t, [2]uint32{0x0badf00d, 0xdeadbeef}, 0x180000)
+	decrypted := randomImage(7, 0x180000)
+	encrypted := swapBytes(NewCipher(key).Encrypt(decrypted))
+	// one word short of giving any seed minRecoveryPairs words
+	_, err := RecoverKey(encrypted, decrypted[:minRecoverySpan-2], 0)
+	if err == nil || !strings.Contains(err.Error(), "must span") {
+		t.Errorf("got error %v, expected one saying the known words must span more", err)
+	}
+}
+
+func TestRecoverUnencrypted(t *testing.T) {
+	image := randomImage(8, 0x1000)
+	key, err := RecoverKey(image, image, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.IsNoEncryption() {
+		t.Errorf("limits are 0x%06x-0x%06x, expected no encryption", key.LowerLimit, key.UpperLimit)
+	}
+}
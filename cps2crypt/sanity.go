@@ -0,0 +1,96 @@
+package cps2crypt
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/MBDesu/mbdcps2/m68k"
+)
+
+// A decrypted maincpu that doesn't look like a 68000 program almost always
+// means the key, and so the ROM set name, is wrong. These are the checks.
+
+// Where CPS2 work RAM starts. The initial stack pointer has to be in it, or be
+// 0 to start at the very top.
+const ramStart = 0xff0000
+
+// How many instructions from the reset PC have to decode.
+const firstInstructions = 16
+
+// How many times more often than in random words the common opcodes have to
+// show up.
+const minOpcodeRatio = 4
+
+// Opcodes nearly every 68000 program is full of: RTS, RTE, NOP, JSR and JMP
+// to absolute longs, MOVEM.L to and from the stack, LINK and UNLK A6.
+var commonOpcodes = []uint16{0x4e75, 0x4e73, 0x4e71, 0x4eb9, 0x4ef9, 0x48e7, 0x4cdf, 0x4e56, 0x4e5e}
+
+// ImplausibleError says why a decrypted maincpu doesn't look like a 68000
+// program.
+type ImplausibleError struct {
+	Problems []string
+	// Candidates are ROM sets whose keys did give a plausible program, if any
+	// were tried
+	Candidates []string
+}
+
+func (e *ImplausibleError) Error() string {
+	s := fmt.Sprintf("decrypted maincpu doesn't look like a 68000 program, the key probably doesn't match the ROM set: %s", strings.Join(e.Problems, "; "))
+	if len(e.Candidates) > 0 {
+		s += fmt.Sprintf("; the keys of %s give a plausible program", strings.Join(e.Candidates, ", "))
+	}
+	return s
+}
+
+// CheckDecrypted checks that a decrypted maincpu looks like a 68000 program:
+// that the reset vector, which the CPU reads as data and so from stored, points
+// the stack at RAM and the PC at code, that the first instructions from there
+// decode, and that common opcodes show up more often than by chance. Both
+// images are big endian. It returns an *ImplausibleError if not.
+func CheckDecrypted(stored []uint8, decrypted []uint8) error {
+	var problems []string
+	if len(stored) < 8 || len(decrypted) != len(stored) {
+		return &ImplausibleError{Problems: []string{"images are too small or differ in size"}}
+	}
+	sp := int(stored[0])<<24 | int(stored[1])<<16 | int(stored[2])<<8 | int(stored[3])
+	pc := int(stored[4])<<24 | int(stored[5])<<16 | int(stored[6])<<8 | int(stored[7])
+	if top := sp & 0xffffff; sp%2 != 0 || top < ramStart && top != 0 {
+		problems = append(problems, fmt.Sprintf("initial SP 0x%08x isn't in RAM", sp))
+	}
+	if !m68k.EntryPointIsValid(pc, len(decrypted)) {
+		problems = append(problems, fmt.Sprintf("initial PC 0x%08x isn't in the program", pc))
+	} else if n := m68k.LegalRun(decrypted, pc, firstInstructions); n < firstInstructions {
+		problems = append(problems, fmt.Sprintf("instruction %d from the initial PC 0x%06x is illegal", n+1, pc))
+	}
+	count := 0
+	for i := 0; i+1 < len(decrypted); i += 2 {
+		if slices.Contains(commonOpcodes, uint16(decrypted[i])<<8|uint16(decrypted[i+1])) {
+			count++
+		}
+	}
+	expected := max(1, len(decrypted)/2*len(commonOpcodes)/0x10000)
+	if count < minOpcodeRatio*expected {
+		problems = append(problems, fmt.Sprintf("only %d common opcodes, random words would have about %d", count, expected))
+	}
+	if len(problems) > 0 {
+		return &ImplausibleError{Problems: problems}
+	}
+	return nil
+}
+
+// FindPlausibleKeys decrypts stored, a big endian maincpu image, with each of
+// keys, by ROM set name, and returns the sorted names of those that give a
+// plausible program.
+func FindPlausibleKeys(stored []uint8, keys map[string]*Key, workers int) []string {
+	var names []string
+	for name, key := range keys {
+		cipher := NewCipher(key)
+		cipher.Workers = workers
+		if CheckDecrypted(stored, cipher.Decrypt(stored)) == nil {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,94 @@
+package cps2crypt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// How many mismatching words a MismatchError lists.
+const maxMismatches = 8
+
+// Mismatch is a word that differs between two images.
+type Mismatch struct {
+	Address  int
+	Expected uint16
+	Actual   uint16
+}
+
+// MismatchError describes how an image differs from what it should be, e.g. an
+// input from the round trip of its output.
+type MismatchError struct {
+	ExpectedSize int
+	ActualSize   int
+	// Count is how many words differ where both images have them, Mismatches
+	// holds the first few
+	Count      int
+	Mismatches []Mismatch
+}
+
+func (e *MismatchError) Error() string {
+	var problems []string
+	if e.ExpectedSize != e.ActualSize {
+		problems = append(problems, fmt.Sprintf("size is 0x%06x bytes, expected 0x%06x", e.ActualSize, e.ExpectedSize))
+	}
+	if e.Count > 0 {
+		mismatches := make([]string, len(e.Mismatches))
+		for i, mismatch := range e.Mismatches {
+			mismatches[i] = fmt.Sprintf("0x%06x (0x%04x, expected 0x%04x)", mismatch.Address, mismatch.Actual, mismatch.Expected)
+		}
+		problem := fmt.Sprintf("%d words differ: %s", e.Count, strings.Join(mismatches, ", "))
+		if e.Count > len(e.Mismatches) {
+			problem += ", ..."
+		}
+		problems = append(problems, problem)
+	}
+	return strings.Join(problems, "; ")
+}
+
+// CompareImages compares two big endian images that start at byte address
+// start. It returns a *MismatchError if they differ.
+func CompareImages(expected []uint8, actual []uint8, start int) error {
+	e := MismatchError{ExpectedSize: len(expected), ActualSize: len(actual)}
+	for i := 0; i+1 < min(len(expected), len(actual)); i += 2 {
+		expectedWord := uint16(expected[i])<<8 | uint16(expected[i+1])
+		actualWord := uint16(actual[i])<<8 | uint16(actual[i+1])
+		if expectedWord == actualWord {
+			continue
+		}
+		if e.Count < maxMismatches {
+			e.Mismatches = append(e.Mismatches, Mismatch{start + i, expectedWord, actualWord})
+		}
+		e.Count++
+	}
+	if e.Count > 0 || e.ExpectedSize != e.ActualSize {
+		return &e
+	}
+	return nil
+}
+
+// VerifyRoundTrip crypts output, the result of crypting input in direction,
+// back the other way and compares it with input. Both are big endian images
+// starting at byte address start, so Encrypt's byte swapped output has to be
+// swapped back, or read back from the ROM files, first.
+func (c *Cipher) VerifyRoundTrip(direction Direction, input []uint8, output []uint8, start int) error {
+	words := createUint16ArrayFromUint8Array(output)
+	if direction == Decrypt {
+		words = c.crypt(Encrypt, words, start/2)
+	} else {
+		words = c.crypt(Decrypt, words, start/2)
+	}
+	return roundTripError(CompareImages(input, createUint8ArrayFromUint16Array(words, Decrypt), start))
+}
+
+// VerifyXorRoundTrip is VerifyRoundTrip for output crypted with an XOR table
+// instead of a key.
+func VerifyXorRoundTrip(input []uint8, output []uint8, xorTable []uint8, start int) error {
+	return roundTripError(CompareImages(input, ApplyXorTable(output, xorTable[min(start, len(xorTable)):]), start))
+}
+
+func roundTripError(err error) error {
+	if err != nil {
+		return fmt.Errorf("round trip failed, %w", err)
+	}
+	return nil
+}
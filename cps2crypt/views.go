@@ -0,0 +1,110 @@
+package cps2crypt
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+
+	"github.com/MBDesu/mbdcps2/m68k"
+)
+
+// CPS2 only decrypts what the CPU fetches as opcodes, everything it reads as
+// data comes from the ROM as stored. A decrypted image is only right where the
+// program runs, so the views are merged using a m68k.CodeMap.
+
+// MergeImages returns the program as the CPU sees it: decrypted words where
+// codeMap says they're read through the opcode view, and encrypted words, as
+// stored, everywhere else. All images are big endian.
+func MergeImages(encrypted []uint8, decrypted []uint8, codeMap m68k.CodeMap) []uint8 {
+	merged := make([]uint8, len(encrypted))
+	copy(merged, encrypted)
+	for i, access := range codeMap {
+		if access.IsOpcodeView() && 2*i+1 < min(len(merged), len(decrypted)) {
+			merged[2*i] = decrypted[2*i]
+			merged[2*i+1] = decrypted[2*i+1]
+		}
+	}
+	return merged
+}
+
+// Views are big endian images of a maincpu as the CPU fetches opcodes and as
+// it reads data.
+type Views struct {
+	Opcodes []uint8
+	Data    []uint8
+}
+
+// SplitMergedImage turns an image laid out like MergeImages' output back into
+// views. The opcode view of words codeMap doesn't put in it, and the data view
+// of words it does, are taken from original.
+func SplitMergedImage(merged []uint8, original Views, codeMap m68k.CodeMap) Views {
+	views := Views{slices.Clone(original.Opcodes), slices.Clone(original.Data)}
+	for i := 0; 2*i+1 < len(merged); i++ {
+		view := views.Data
+		if i < len(codeMap) && codeMap[i].IsOpcodeView() {
+			view = views.Opcodes
+		}
+		if 2*i+1 < len(view) {
+			view[2*i] = merged[2*i]
+			view[2*i+1] = merged[2*i+1]
+		}
+	}
+	return views
+}
+
+// ResolveViews works out, word by word, what the ROM has to store for the CPU
+// to see edited. Words codeMap puts in the opcode view are taken from
+// encryptedOpcodes, edited.Opcodes as encrypted by Encrypt or
+// CryptWithXorTable, and words it puts in the data view from edited.Data.
+// Unknown words could be either, so they're taken from whichever view differs
+// from original, the encrypted opcode view if both do.
+//
+// The result is byte swapped like Encrypt's. Unknown words that were edited are
+// returned as ambiguous ranges, since the CPU may not see them as intended.
+func ResolveViews(encryptedOpcodes []uint8, edited Views, original Views, codeMap m68k.CodeMap) ([]uint8, []m68k.Range, error) {
+	size := len(original.Data)
+	if len(encryptedOpcodes) != size || len(edited.Opcodes) != size || len(edited.Data) != size || len(original.Opcodes) != size {
+		return nil, nil, fmt.Errorf("views differ in size, expected 0x%06x bytes", size)
+	}
+	word := func(image []uint8, i int) (uint8, uint8) {
+		return image[2*i], image[2*i+1]
+	}
+	stored := make([]uint8, size)
+	var ambiguous []m68k.Range
+	for i := 0; 2*i+1 < size; i++ {
+		access := m68k.Unknown
+		if i < len(codeMap) {
+			access = codeMap[i]
+		}
+		// encryptedOpcodes is byte swapped already, the data view isn't
+		fromOpcodes := func() {
+			stored[2*i], stored[2*i+1] = word(encryptedOpcodes, i)
+		}
+		fromData := func() {
+			stored[2*i+1], stored[2*i] = word(edited.Data, i)
+		}
+		switch {
+		case access.IsOpcodeView():
+			fromOpcodes()
+		case access == m68k.Data:
+			fromData()
+		default:
+			opcodesEdited := !bytes.Equal(edited.Opcodes[2*i:2*i+2], original.Opcodes[2*i:2*i+2])
+			dataEdited := !bytes.Equal(edited.Data[2*i:2*i+2], original.Data[2*i:2*i+2])
+			if opcodesEdited {
+				fromOpcodes()
+			} else {
+				fromData()
+			}
+			if !opcodesEdited && !dataEdited {
+				continue
+			}
+			if len(ambiguous) > 0 && ambiguous[len(ambiguous)-1].End == 2*i {
+				ambiguous[len(ambiguous)-1].End += 2
+			} else {
+				ambiguous = append(ambiguous, m68k.Range{Start: 2 * i, End: 2*i + 2, Access: access})
+			}
+		}
+	}
+	return stored, ambiguous, nil
+}
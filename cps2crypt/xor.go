@@ -0,0 +1,40 @@
+package cps2crypt
+
+import (
+	"fmt"
+)
+
+// An XOR table is the decrypted maincpu image XORed with the encrypted one,
+// which is how older emulators described CPS2 decryption. Tables here are big
+// endian like the decrypted .bin; swap the bytes of tables taken from
+// byte swapped ROM files first.
+
+// NewXorTable builds an XOR table from big endian encrypted and decrypted
+// images of the same size.
+func NewXorTable(encrypted []uint8, decrypted []uint8) ([]uint8, error) {
+	if len(encrypted) != len(decrypted) {
+		return nil, fmt.Errorf("encrypted and decrypted binaries differ in size (0x%06x and 0x%06x)", len(encrypted), len(decrypted))
+	}
+	return ApplyXorTable(encrypted, decrypted), nil
+}
+
+// ApplyXorTable XORs romBinary with xorTable. Bytes past the end of the table
+// are left as they are.
+func ApplyXorTable(romBinary []uint8, xorTable []uint8) []uint8 {
+	out := make([]uint8, len(romBinary))
+	copy(out, romBinary)
+	for i := range min(len(out), len(xorTable)) {
+		out[i] ^= xorTable[i]
+	}
+	return out
+}
+
+// CryptWithXorTable stands in for Crypt when a set only has an XOR table. Its
+// output is in the same byte order as Crypt's for the given direction.
+func CryptWithXorTable(direction Direction, romBinary []uint8, xorTable []uint8) []uint8 {
+	out := ApplyXorTable(romBinary, xorTable)
+	if direction == Encrypt {
+		return createUint8ArrayFromUint16Array(createUint16ArrayFromUint8Array(out), Encrypt)
+	}
+	return out
+}
@@ -0,0 +1,185 @@
+package cps2rom
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"slices"
+	"strings"
+)
+
+// FileStatus is what an audit found out about a file.
+type FileStatus string
+
+const (
+	// FileGood files have the size and hashes of the definition
+	FileGood FileStatus = "good"
+	// FileUnverified files have the right size, but the definition has no
+	// hashes to check them against
+	FileUnverified FileStatus = "unverified"
+	FileMissing    FileStatus = "missing"
+	FileWrongSize  FileStatus = "wrong size"
+	// FileBadDump files have the right size, but hashes no ROM set has
+	FileBadDump FileStatus = "bad dump"
+	// FileWrongRevision files have the hashes of another file, usually the
+	// same file of another revision of the game
+	FileWrongRevision FileStatus = "wrong revision"
+	// FileExtra files aren't in the definition
+	FileExtra FileStatus = "extra"
+)
+
+// IsProblem reports whether a file with this status keeps its ROM set from
+// working as it should.
+func (s FileStatus) IsProblem() bool {
+	return s == FileMissing || s == FileWrongSize || s == FileBadDump || s == FileWrongRevision
+}
+
+// FileAudit is the audit of one file of a ROM set.
+type FileAudit struct {
+	Filename string     `json:"filename"`
+	Status   FileStatus `json:"status"`
+	Size     int        `json:"size,omitempty"`
+	Crc      string     `json:"crc,omitempty"`
+	Sha1     string     `json:"sha1,omitempty"`
+	Expected *RomFile   `json:"expected,omitempty"`
+	// Is, for a wrong revision, the ROM set and file whose hashes it has
+	Is string `json:"is,omitempty"`
+}
+
+const (
+	VerdictGood       = "good"
+	VerdictUnverified = "unverified"
+	VerdictBad        = "bad"
+)
+
+// SetAudit is the audit of a ROM set. Its Verdict is bad if any file has a
+// problem, unverified if some files couldn't be checked, and good otherwise.
+// Extra files don't change it.
+type SetAudit struct {
+	RomSetName string      `json:"romSetName"`
+	Verdict    string      `json:"verdict"`
+	Files      []FileAudit `json:"files"`
+	// Problem is why the ROM set couldn't be audited at all, if it couldn't
+	Problem string `json:"problem,omitempty"`
+}
+
+// Counts returns how many files there are of each status.
+func (a SetAudit) Counts() map[FileStatus]int {
+	counts := map[FileStatus]int{}
+	for _, file := range a.Files {
+		counts[file.Status]++
+	}
+	return counts
+}
+
+// Summary lists how many files there are of each status, problems first.
+func (a SetAudit) Summary() string {
+	counts := a.Counts()
+	var parts []string
+	for _, status := range []FileStatus{FileBadDump, FileWrongRevision, FileWrongSize, FileMissing, FileExtra, FileUnverified, FileGood} {
+		if counts[status] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[status], status))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+type indexedFile struct {
+	name string
+	file RomFile
+}
+
+// hashIndex maps the crc of every file in RomDefinitions to the ROM sets and
+// files that have it.
+var hashIndex map[string][]indexedFile
+
+// lookupHashes returns the ROM sets and files, as <ROM set>:<file>, with the
+// given hashes.
+func lookupHashes(crc string, sha1 string) []string {
+	if hashIndex == nil {
+		hashIndex = map[string][]indexedFile{}
+		for romSetName, romDef := range *RomDefinitions {
+			for filename, file := range romDef.Files() {
+				if file.Crc != "" {
+					hashIndex[file.Crc] = append(hashIndex[file.Crc], indexedFile{romSetName + ":" + filename, file})
+				}
+			}
+		}
+	}
+	var names []string
+	for _, indexed := range hashIndex[crc] {
+		if hashesMatch(indexed.file, crc, sha1) {
+			names = append(names, indexed.name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// hashesMatch compares hashes in lowercase hex. A missing sha1 is left out.
+func hashesMatch(file RomFile, crc string, sha1 string) bool {
+	return file.Crc == crc && (file.Sha1 == "" || file.Sha1 == sha1)
+}
+
+// AuditRomZip checks every file of romDef in romZip, a .zip or directory, against the sizes and
+// hashes of the definition, and lists the files it doesn't have. Files of
+// romSetName's clones, as in a merged set, aren't extra.
+func AuditRomZip(romSetName string, romDef RomDefinition, romZip *RomSource) (SetAudit, error) {
+	audit := SetAudit{RomSetName: romSetName, Verdict: VerdictGood}
+	files := romDef.Files()
+	cloneFiles := cloneFilenames(romSetName)
+	zipFiles := map[string]*SourceFile{}
+	for _, file := range romZip.File {
+		zipFiles[file.Name] = file
+		if _, ok := files[file.Name]; !ok && !cloneFiles[file.Name] {
+			audit.Files = append(audit.Files, FileAudit{Filename: file.Name, Status: FileExtra, Size: file.Size})
+		}
+	}
+	for filename, file := range files {
+		expected := file
+		fileAudit := FileAudit{Filename: filename, Expected: &expected}
+		zipFile, ok := zipFiles[filename]
+		if !ok {
+			fileAudit.Status = FileMissing
+			audit.Files = append(audit.Files, fileAudit)
+			continue
+		}
+		contents, err := zipFile.ReadAll()
+		if err != nil {
+			return audit, fmt.Errorf("%s: %w", filename, err)
+		}
+		sha1Sum := sha1.Sum(contents)
+		fileAudit.Size = len(contents)
+		fileAudit.Crc = fmt.Sprintf("%08x", crc32.ChecksumIEEE(contents))
+		fileAudit.Sha1 = hex.EncodeToString(sha1Sum[:])
+		switch {
+		case fileAudit.Size != file.Size:
+			fileAudit.Status = FileWrongSize
+		case file.Crc == "":
+			fileAudit.Status = FileUnverified
+		case hashesMatch(file, fileAudit.Crc, fileAudit.Sha1):
+			fileAudit.Status = FileGood
+		default:
+			fileAudit.Status = FileBadDump
+			if names := lookupHashes(fileAudit.Crc, fileAudit.Sha1); len(names) > 0 {
+				fileAudit.Status = FileWrongRevision
+				fileAudit.Is = strings.Join(names, ", ")
+			}
+		}
+		audit.Files = append(audit.Files, fileAudit)
+	}
+	slices.SortFunc(audit.Files, func(a FileAudit, b FileAudit) int {
+		return strings.Compare(a.Filename, b.Filename)
+	})
+	for _, file := range audit.Files {
+		if file.Status.IsProblem() {
+			audit.Verdict = VerdictBad
+			break
+		}
+		if file.Status == FileUnverified {
+			audit.Verdict = VerdictUnverified
+		}
+	}
+	return audit, nil
+}
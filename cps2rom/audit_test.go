@@ -0,0 +1,126 @@
+package cps2rom
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func hashes(contents []byte) (string, string) {
+	sha1Sum := sha1.Sum(contents)
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE(contents)), hex.EncodeToString(sha1Sum[:])
+}
+
+// useRomDefinitions swaps RomDefinitions for roms until the test ends.
+func useRomDefinitions(t *testing.T, roms Roms) {
+	t.Helper()
+	builtIn, builtInIndex := RomDefinitions, hashIndex
+	RomDefinitions, hashIndex = &roms, nil
+	t.Cleanup(func() {
+		RomDefinitions, hashIndex = builtIn, builtInIndex
+	})
+}
+
+// writeRomDir writes files to a new directory of loose files and opens it.
+func writeRomDir(t *testing.T, files map[string][]byte) *RomSource {
+	t.Helper()
+	dir := t.TempDir()
+	for filename, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, filename), contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	romSource, err := OpenRomSource(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { romSource.Close() })
+	return romSource
+}
+
+func TestAuditRomZip(t *testing.T) {
+	files := map[string][]byte{
+		"t.03":  []byte("maincpu, first file"),
+		"t.04":  []byte("maincpu, second file"),
+		"t.01":  []byte("audiocpu"),
+		"t.key": []byte("key"),
+	}
+	revisionB := []byte("maincpu, revision B!")
+	load := func(offset int, filename string, contents []byte) RomRegionOperation {
+		crc, sha1 := hashes(contents)
+		return RomRegionOperation{Offset: offset, Length: len(contents), Type: "load", Filename: filename, Crc: crc, Sha1: sha1}
+	}
+	romDef := RomDefinition{
+		Maincpu:  RomRegion{Size: 0x40, Operations: []RomRegionOperation{load(0, "t.03", files["t.03"]), load(0x20, "t.04", files["t.04"])}},
+		Audiocpu: RomRegion{Size: 0x10, Operations: []RomRegionOperation{load(0, "t.01", files["t.01"])}},
+		// no hashes
+		Key: RomRegion{Size: 0x10, Operations: []RomRegionOperation{{Length: len(files["t.key"]), Type: "load", Filename: "t.key"}}},
+	}
+	revision := RomDefinition{Maincpu: RomRegion{Size: 0x40, Operations: []RomRegionOperation{load(0x20, "tb.04", revisionB)}}}
+	useRomDefinitions(t, Roms{"test": romDef, "testb": revision})
+
+	tests := []struct {
+		name    string
+		replace map[string][]byte
+		want    map[string]FileStatus
+		verdict string
+	}{
+		{
+			name:    "good",
+			want:    map[string]FileStatus{"t.01": FileGood, "t.03": FileGood, "t.04": FileGood, "t.key": FileUnverified},
+			verdict: VerdictUnverified,
+		},
+		{
+			name:    "corrupted",
+			replace: map[string][]byte{"t.04": []byte("maincpu, secXnd file")},
+			want:    map[string]FileStatus{"t.01": FileGood, "t.03": FileGood, "t.04": FileBadDump, "t.key": FileUnverified},
+			verdict: VerdictBad,
+		},
+		{
+			name:    "other revision",
+			replace: map[string][]byte{"t.04": revisionB},
+			want:    map[string]FileStatus{"t.01": FileGood, "t.03": FileGood, "t.04": FileWrongRevision, "t.key": FileUnverified},
+			verdict: VerdictBad,
+		},
+		{
+			name:    "wrong size, missing and extra",
+			replace: map[string][]byte{"t.01": []byte("audio"), "t.03": nil, "t.02": []byte("extra")},
+			want:    map[string]FileStatus{"t.01": FileWrongSize, "t.02": FileExtra, "t.03": FileMissing, "t.04": FileGood, "t.key": FileUnverified},
+			verdict: VerdictBad,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFiles := map[string][]byte{}
+			for filename, contents := range files {
+				setFiles[filename] = contents
+			}
+			for filename, contents := range tt.replace {
+				if contents == nil {
+					delete(setFiles, filename)
+				} else {
+					setFiles[filename] = contents
+				}
+			}
+			audit, err := AuditRomZip("test", romDef, writeRomDir(t, setFiles))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if audit.Verdict != tt.verdict {
+				t.Errorf("verdict is %s, expected %s", audit.Verdict, tt.verdict)
+			}
+			if len(audit.Files) != len(tt.want) {
+				t.Errorf("audited %d files, expected %d", len(audit.Files), len(tt.want))
+			}
+			for _, file := range audit.Files {
+				if file.Status != tt.want[file.Filename] {
+					t.Errorf("%s is %s, expected %s", file.Filename, file.Status, tt.want[file.Filename])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package cps2rom
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/MBDesu/mbdcps2/Resources"
+	file_utils "github.com/MBDesu/mbdcps2/utils"
+)
+
+// The Darksoft CPS2 multi cart reads each game from a folder with a file per
+// region, rather than MAME's dumps of each chip:
+//
+// | File | Region   | Contents                                    |
+// | ---- | -------- | ------------------------------------------- |
+// | 01   | maincpu  | the region, big endian, as concat writes it |
+// | 02   | audiocpu | its files one after another                 |
+// | 03   | qsound   | its files one after another                 |
+// | 04   | gfx      | the region, its files interleaved           |
+// | key  | key      | the key                                     |
+
+type darksoftFile struct {
+	name   string
+	region func(RomDefinition) RomRegion
+	// whether the file is the region as loaded, rather than its files
+	// concatenated in load order
+	isRegion bool
+}
+
+var darksoftFiles = []darksoftFile{
+	{"01", func(romDef RomDefinition) RomRegion { return romDef.Maincpu }, true},
+	{"02", func(romDef RomDefinition) RomRegion { return romDef.Audiocpu }, false},
+	{"03", func(romDef RomDefinition) RomRegion { return romDef.Qsound }, false},
+	{"04", func(romDef RomDefinition) RomRegion { return romDef.Gfx }, true},
+	{"key", func(romDef RomDefinition) RomRegion { return romDef.Key }, false},
+}
+
+// IsDarksoftDir reports whether directory is a Darksoft game folder.
+func IsDarksoftDir(directory string) bool {
+	for _, name := range []string{"01", "key"} {
+		info, err := os.Stat(filepath.Join(directory, name))
+		if err != nil || !info.Mode().IsRegular() {
+			return false
+		}
+	}
+	return true
+}
+
+// regionFilenames returns the names of the files region loads, in load order.
+func regionFilenames(region RomRegion) []string {
+	var filenames []string
+	for _, operation := range region.Operations {
+		if strings.ToLower(operation.Type) == "load" && operation.Filename != "" && !slices.Contains(filenames, operation.Filename) {
+			filenames = append(filenames, operation.Filename)
+		}
+	}
+	return filenames
+}
+
+// WriteDarksoftDir writes the files of romDef in romSource to a Darksoft game
+// folder at outputDir.
+func WriteDarksoftDir(romSource *RomSource, romDef RomDefinition, outputDir string) error {
+	if err := ValidateRomZip(romDef, romSource); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return err
+	}
+	for _, file := range darksoftFiles {
+		region := file.region(romDef)
+		if len(region.Operations) == 0 {
+			continue
+		}
+		var contents []byte
+		if file.isRegion {
+			regionBinary, err := ProcessRegionFromZip(romSource, region)
+			if err != nil {
+				return err
+			}
+			contents = regionBinary
+		} else {
+			for _, filename := range regionFilenames(region) {
+				fileContents, err := romSource.ReadFile(filename)
+				if err != nil {
+					return err
+				}
+				contents = append(contents, fileContents...)
+			}
+		}
+		Resources.Logger.Info(fmt.Sprintf("Writing %s...", file.name))
+		if err := file_utils.WriteBytesToFile(filepath.Join(outputDir, file.name), contents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadDarksoftDir splits the Darksoft game folder at directory, of ROM set
+// romSetName, back into its MAME files and writes them to a .zip at
+// outputFilepath.
+func ReadDarksoftDir(romSetName string, directory string, outputFilepath string) error {
+	romDef, ok := (*RomDefinitions)[romSetName]
+	if !ok {
+		return fmt.Errorf("ROM set %s is invalid or unsupported", romSetName)
+	}
+	romFiles := romDef.Files()
+	files := map[string][]byte{}
+	for _, file := range darksoftFiles {
+		region := file.region(romDef)
+		if len(region.Operations) == 0 {
+			continue
+		}
+		contents, err := os.ReadFile(filepath.Join(directory, file.name))
+		if err != nil {
+			return err
+		}
+		if file.isRegion {
+			regionFiles, err := SplitRegion(region, contents)
+			if err != nil {
+				return fmt.Errorf("%s: %w", file.name, err)
+			}
+			for filename, fileContents := range regionFiles {
+				files[filename] = fileContents
+			}
+			continue
+		}
+		size := 0
+		for _, filename := range regionFilenames(region) {
+			size += romFiles[filename].Size
+		}
+		if len(contents) != size {
+			return fmt.Errorf("%s is 0x%x bytes, expected 0x%x", file.name, len(contents), size)
+		}
+		for _, filename := range regionFilenames(region) {
+			files[filename], contents = contents[:romFiles[filename].Size], contents[romFiles[filename].Size:]
+		}
+	}
+	return writeZipContents(outputFilepath, files)
+}
+
+// writeZipContents writes contents to a new .zip at outputFilepath, by name.
+func writeZipContents(outputFilepath string, contents map[string][]byte) error {
+	f, err := file_utils.CreateFile(outputFilepath)
+	if err != nil {
+		return err
+	}
+	w := zip.NewWriter(f)
+	filenames := make([]string, 0, len(contents))
+	for filename := range contents {
+		filenames = append(filenames, filename)
+	}
+	slices.Sort(filenames)
+	for _, filename := range filenames {
+		Resources.Logger.Info(fmt.Sprintf("Writing %s...", filename))
+		fw, err := w.Create(filename)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(contents[filename]); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,73 @@
+package cps2rom
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// The CPS2 board's gfx ROMs hold each 2MB bank of tiles shuffled, in 8 byte
+// units, by address lines the board swaps. MAME's cps2_gfx_decode unshuffles
+// every bank of the gfx region, leaving its tiles one after another in CPS1's
+// 4bpp layout, each row a 64 bit word of 4 bitplanes.
+
+const GfxBankSize = 0x200000
+
+// UnshuffleGfx returns the gfx region as MAME decodes it, with its tiles in
+// order.
+func UnshuffleGfx(gfx []byte) ([]byte, error) {
+	return shuffleGfx(gfx, unshuffle)
+}
+
+// ReshuffleGfx returns the gfx region of an unshuffled image, as the board's
+// ROMs hold it. It undoes UnshuffleGfx.
+func ReshuffleGfx(linear []byte) ([]byte, error) {
+	return shuffleGfx(linear, reshuffle)
+}
+
+func shuffleGfx(gfx []byte, shuffle func([]uint64)) ([]byte, error) {
+	if len(gfx) == 0 || len(gfx)%GfxBankSize != 0 {
+		return nil, fmt.Errorf("gfx is 0x%x bytes, expected a multiple of 0x%x", len(gfx), GfxBankSize)
+	}
+	words := make([]uint64, len(gfx)/8)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint64(gfx[i*8:])
+	}
+	for bank := 0; bank < len(words); bank += GfxBankSize / 8 {
+		shuffle(words[bank : bank+GfxBankSize/8])
+	}
+	shuffled := make([]byte, len(gfx))
+	for i, word := range words {
+		binary.LittleEndian.PutUint64(shuffled[i*8:], word)
+	}
+	return shuffled, nil
+}
+
+// unshuffle is MAME's: it unshuffles each half, then swaps their inner
+// quarters.
+func unshuffle(buf []uint64) {
+	if len(buf) == 2 {
+		return
+	}
+	half := len(buf) / 2
+	unshuffle(buf[:half])
+	unshuffle(buf[half:])
+	swapQuarters(buf)
+}
+
+// reshuffle undoes unshuffle, in the reverse order.
+func reshuffle(buf []uint64) {
+	if len(buf) == 2 {
+		return
+	}
+	half := len(buf) / 2
+	swapQuarters(buf)
+	reshuffle(buf[:half])
+	reshuffle(buf[half:])
+}
+
+func swapQuarters(buf []uint64) {
+	half := len(buf) / 2
+	for i := range half / 2 {
+		buf[half/2+i], buf[half+i] = buf[half+i], buf[half/2+i]
+	}
+}
@@ -0,0 +1,74 @@
+package cps2rom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand/v2"
+	"testing"
+)
+
+func randomBytes(seed uint64, size int) []byte {
+	r := rand.New(rand.NewPCG(seed, seed))
+	contents := make([]byte, size)
+	for i := range contents {
+		contents[i] = uint8(r.Uint32())
+	}
+	return contents
+}
+
+func TestShuffleGfxRoundTrip(t *testing.T) {
+	gfx := randomBytes(1, 2*GfxBankSize)
+	linear, err := UnshuffleGfx(gfx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(linear, gfx) {
+		t.Fatal("unshuffling didn't move anything")
+	}
+	reshuffled, err := ReshuffleGfx(linear)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reshuffled, gfx) {
+		t.Error("ReshuffleGfx(UnshuffleGfx(x)) isn't x")
+	}
+	unshuffled, err := UnshuffleGfx(reshuffled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unshuffled, linear) {
+		t.Error("UnshuffleGfx(ReshuffleGfx(x)) isn't x")
+	}
+}
+
+// MAME's cps2_gfx_decode leaves each bank's even 8 byte units in its first
+// half, in order, and its odd ones in its second.
+func TestUnshuffleGfxLayout(t *testing.T) {
+	gfx := make([]byte, 2*GfxBankSize)
+	for i := range len(gfx) / 8 {
+		binary.LittleEndian.PutUint64(gfx[i*8:], uint64(i))
+	}
+	linear, err := UnshuffleGfx(gfx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	units := GfxBankSize / 8
+	for i := range len(linear) / 8 {
+		bank, j := i/units*units, i%units
+		want := bank + 2*j
+		if j >= units/2 {
+			want = bank + 2*(j-units/2) + 1
+		}
+		if got := binary.LittleEndian.Uint64(linear[i*8:]); got != uint64(want) {
+			t.Fatalf("unit 0x%x of the unshuffled gfx is unit 0x%x, expected 0x%x", i, got, want)
+		}
+	}
+}
+
+func TestShuffleGfxSize(t *testing.T) {
+	for _, size := range []int{0, 8, GfxBankSize + 8} {
+		if _, err := UnshuffleGfx(make([]byte, size)); err == nil {
+			t.Errorf("unshuffling 0x%x bytes didn't fail", size)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package cps2rom
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/MBDesu/mbdcps2/Resources"
+)
+
+// How many close ROM sets an UnidentifiedError lists.
+const maxCandidates = 5
+
+// SetMatch is how well the files in a ROM .zip match a ROM set's definition.
+type SetMatch struct {
+	RomSetName string
+	Matched    []string
+	Missing    []string
+	WrongSize  []string
+	// InParent files are missing, but shared with the parent ROM set, as in
+	// a split clone
+	InParent []string
+	// Extra files are in the .zip, but not in the definition or those of the
+	// ROM set's clones, which a merged set has too
+	Extra []string
+}
+
+// IsComplete reports whether the .zip has every file of the ROM set, at the
+// right size.
+func (m SetMatch) IsComplete() bool {
+	return len(m.Missing) == 0 && len(m.WrongSize) == 0
+}
+
+func (m SetMatch) String() string {
+	s := fmt.Sprintf("%s: %d files match", m.RomSetName, len(m.Matched))
+	if len(m.InParent) > 0 {
+		s += fmt.Sprintf(", %d in parent %s", len(m.InParent), GetRomDefinition(m.RomSetName).Parent)
+	}
+	if len(m.Missing) > 0 {
+		s += fmt.Sprintf(", missing %s", strings.Join(m.Missing, ", "))
+	}
+	if len(m.WrongSize) > 0 {
+		s += fmt.Sprintf(", wrong size %s", strings.Join(m.WrongSize, ", "))
+	}
+	if len(m.Extra) > 0 {
+		s += fmt.Sprintf(", extra %s", strings.Join(m.Extra, ", "))
+	}
+	return s
+}
+
+// MatchRomZip compares the names and sizes of the files in romZip with those
+// of romDef. Files it shares with its parent may be missing.
+func MatchRomZip(romSetName string, romDef RomDefinition, romZip *RomSource) SetMatch {
+	m := SetMatch{RomSetName: romSetName}
+	files := romDef.Files()
+	shared := romDef.SharedFiles()
+	cloneFiles := cloneFilenames(romSetName)
+	zipSizes := map[string]int{}
+	for _, file := range romZip.File {
+		zipSizes[file.Name] = file.Size
+		if _, ok := files[file.Name]; !ok && !cloneFiles[file.Name] {
+			m.Extra = append(m.Extra, file.Name)
+		}
+	}
+	for filename, file := range files {
+		zipSize, ok := zipSizes[filename]
+		switch {
+		case !ok && shared[filename].Size > 0:
+			m.InParent = append(m.InParent, filename)
+		case !ok:
+			m.Missing = append(m.Missing, filename)
+		case zipSize != file.Size:
+			m.WrongSize = append(m.WrongSize, filename)
+		default:
+			m.Matched = append(m.Matched, filename)
+		}
+	}
+	slices.Sort(m.Matched)
+	slices.Sort(m.Missing)
+	slices.Sort(m.WrongSize)
+	slices.Sort(m.InParent)
+	slices.Sort(m.Extra)
+	return m
+}
+
+// IdentifyRomZip matches romZip against every ROM set and returns the matches
+// best first: complete ones, with the fewest extra files, before incomplete
+// ones, with the fewest missing or wrong size files. Ties go to the ROM set
+// named like zipFilepath, then by name. ROM sets with no matching files are
+// left out.
+func IdentifyRomZip(romZip *RomSource, zipFilepath string) []SetMatch {
+	zipName := strings.TrimSuffix(filepath.Base(zipFilepath), filepath.Ext(zipFilepath))
+	var matches []SetMatch
+	for romSetName, romDef := range *RomDefinitions {
+		m := MatchRomZip(romSetName, romDef, romZip)
+		if len(m.Matched) > 0 {
+			matches = append(matches, m)
+		}
+	}
+	rank := func(m SetMatch) []int {
+		isNamed := 1
+		if m.RomSetName == zipName {
+			isNamed = 0
+		}
+		if m.IsComplete() {
+			return []int{0, len(m.Extra), isNamed}
+		}
+		return []int{1, len(m.Missing) + len(m.WrongSize), isNamed}
+	}
+	slices.SortFunc(matches, func(a SetMatch, b SetMatch) int {
+		if c := slices.Compare(rank(a), rank(b)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.RomSetName, b.RomSetName)
+	})
+	return matches
+}
+
+// UnidentifiedError lists the ROM sets closest to a .zip that doesn't match
+// exactly one.
+type UnidentifiedError struct {
+	Candidates []SetMatch
+}
+
+func (e *UnidentifiedError) Error() string {
+	if len(e.Candidates) == 0 {
+		return "no supported ROM set has any of these files, specify one with -n"
+	}
+	logString := "couldn't tell which ROM set this is, specify one with -n. Closest:\n"
+	for _, candidate := range e.Candidates {
+		logString += "    " + Resources.LogText.Bold(candidate.RomSetName) + strings.TrimPrefix(candidate.String(), candidate.RomSetName) + "\n"
+	}
+	return logString
+}
+
+// IdentifyRomSet returns the name of the ROM set romZip holds. That's the
+// only complete match, or the one with fewer extra files than the rest, or
+// the one named like zipFilepath among those with the fewest. Otherwise it
+// returns an *UnidentifiedError.
+func IdentifyRomSet(romZip *RomSource, zipFilepath string) (string, error) {
+	matches := IdentifyRomZip(romZip, zipFilepath)
+	if len(matches) == 0 || !matches[0].IsComplete() {
+		return "", &UnidentifiedError{matches[:min(len(matches), maxCandidates)]}
+	}
+	zipName := strings.TrimSuffix(filepath.Base(zipFilepath), filepath.Ext(zipFilepath))
+	best := matches[0]
+	isTied := len(matches) > 1 && matches[1].IsComplete() && len(matches[1].Extra) == len(best.Extra)
+	if isTied && best.RomSetName != zipName {
+		var candidates []SetMatch
+		for _, m := range matches {
+			if m.IsComplete() && len(m.Extra) == len(best.Extra) {
+				candidates = append(candidates, m)
+			}
+		}
+		return "", &UnidentifiedError{candidates[:min(len(candidates), maxCandidates)]}
+	}
+	Resources.Logger.Info(fmt.Sprintf("Identified ROM set %s", best.RomSetName))
+	return best.RomSetName, nil
+}
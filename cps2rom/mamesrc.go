@@ -0,0 +1,307 @@
+package cps2rom
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MAME describes each ROM set of a driver with ROM_START...ROM_END macros, and
+// its parent with a GAME macro. This reads those of cps2.cpp into definitions,
+// so roms.json can be regenerated when MAME adds clones or corrects dumps.
+
+// How the files of each load macro are interleaved into their region.
+type loadLayout struct {
+	groupSize int
+	skip      int
+	reverse   bool
+}
+
+var loadLayouts = map[string]loadLayout{
+	"ROM_LOAD":             {1, 0, false},
+	"ROM_LOAD16_BYTE":      {1, 1, false},
+	"ROM_LOAD16_WORD":      {1, 0, false},
+	"ROM_LOAD16_WORD_SWAP": {2, 0, true},
+	"ROM_LOAD32_BYTE":      {1, 3, false},
+	"ROM_LOAD32_WORD":      {2, 2, false},
+	"ROM_LOAD32_WORD_SWAP": {2, 2, true},
+	"ROM_LOAD64_BYTE":      {1, 7, false},
+	"ROM_LOAD64_WORD":      {2, 6, false},
+	"ROM_LOAD64_WORD_SWAP": {2, 6, true},
+}
+
+var (
+	commentPattern = regexp.MustCompile(`(?s)/\*.*?\*/|//[^\n]*`)
+	definePattern  = regexp.MustCompile(`(?m)^[ \t]*#[ \t]*define[ \t]+(\w+)[ \t]+([^\n]*?)[ \t]*$`)
+	// whole preprocessor directives, including continued lines
+	directivePattern = regexp.MustCompile(`(?m)^[ \t]*#(?:[^\n]*\\\n)*[^\n]*`)
+	macroPattern     = regexp.MustCompile(`\b(ROM_START|ROM_END|ROM_REGION\w*|ROMX_LOAD|ROM_LOAD\w*|ROM_CONTINUE|ROM_FILL|ROM_IGNORE|ROM_RELOAD|ROM_COPY|GAMEL?)\b`)
+	crcPattern       = regexp.MustCompile(`CRC\(\s*([0-9a-fA-F]+)\s*\)`)
+	sha1Pattern      = regexp.MustCompile(`SHA1\(\s*([0-9a-fA-F]+)\s*\)`)
+	skipFlagPattern  = regexp.MustCompile(`ROM_SKIP\(\s*(\w+)\s*\)`)
+	groupFlagPattern = regexp.MustCompile(`ROM_GROUPSIZE\(\s*(\w+)\s*\)`)
+)
+
+// mameSource holds the state of a driver source as it's parsed.
+type mameSource struct {
+	text    string
+	defines map[string]string
+	roms    Roms
+	parents map[string]string
+	// the set and region being defined, if any
+	romSetName string
+	romDef     RomDefinition
+	region     *RomRegion
+}
+
+// ParseMameDriver reads the ROM definitions of a MAME driver source, such as
+// cps2.cpp. Only the maincpu, audiocpu, qsound, gfx and key regions are kept.
+func ParseMameDriver(source []byte) (Roms, error) {
+	s := mameSource{defines: map[string]string{}, roms: Roms{}, parents: map[string]string{}}
+	text := commentPattern.ReplaceAllString(string(source), "")
+	for _, define := range definePattern.FindAllStringSubmatch(text, -1) {
+		s.defines[define[1]] = define[2]
+	}
+	s.text = directivePattern.ReplaceAllString(text, "")
+	for _, loc := range macroPattern.FindAllStringSubmatchIndex(s.text, -1) {
+		macro := s.text[loc[2]:loc[3]]
+		args, err := macroArgs(s.text[loc[1]:])
+		if err != nil {
+			return nil, fmt.Errorf("%s in %s: %w", macro, s.setName(), err)
+		}
+		if err := s.apply(macro, args); err != nil {
+			return nil, fmt.Errorf("%s in %s: %w", macro, s.setName(), err)
+		}
+	}
+	if s.romSetName != "" {
+		return nil, fmt.Errorf("%s: ROM_START without ROM_END", s.romSetName)
+	}
+	for romSetName, parent := range s.parents {
+		romDef, ok := s.roms[romSetName]
+		if _, hasParent := s.roms[parent]; ok && hasParent {
+			romDef.Parent = parent
+			s.roms[romSetName] = romDef
+		}
+	}
+	return s.roms, nil
+}
+
+func (s *mameSource) setName() string {
+	if s.romSetName == "" {
+		return "driver"
+	}
+	return s.romSetName
+}
+
+// macroArgs splits the parenthesised arguments at the start of text on their
+// top level commas. It returns nil for a macro without arguments.
+func macroArgs(text string) ([]string, error) {
+	trimmed := strings.TrimLeft(text, " \t\r\n")
+	if !strings.HasPrefix(trimmed, "(") {
+		return nil, nil
+	}
+	var args []string
+	depth, start := 0, 1
+	isString := false
+	for i, c := range trimmed {
+		switch {
+		case c == '"':
+			isString = !isString
+		case isString:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return append(args, strings.TrimSpace(trimmed[start:i])), nil
+			}
+		case c == ',' && depth == 1:
+			args = append(args, strings.TrimSpace(trimmed[start:i]))
+			start = i + 1
+		}
+	}
+	return nil, fmt.Errorf("unbalanced parentheses")
+}
+
+// number evaluates a numeric argument, which may be a #define.
+func (s *mameSource) number(arg string) (int, error) {
+	for range 8 {
+		value, ok := s.defines[arg]
+		if !ok {
+			break
+		}
+		arg = strings.Trim(value, "() \t")
+	}
+	n, err := strconv.ParseInt(strings.TrimRight(arg, "uUlL"), 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("can't evaluate %s", arg)
+	}
+	return int(n), nil
+}
+
+func (s *mameSource) numbers(args []string, count int) ([]int, error) {
+	if len(args) < count {
+		return nil, fmt.Errorf("expected %d arguments, got %d", count, len(args))
+	}
+	numbers := make([]int, count)
+	for i, arg := range args[:count] {
+		n, err := s.number(arg)
+		if err != nil {
+			return nil, err
+		}
+		numbers[i] = n
+	}
+	return numbers, nil
+}
+
+func (s *mameSource) apply(macro string, args []string) error {
+	switch {
+	case macro == "GAME", macro == "GAMEL":
+		// GAME(year, name, parent, machine, input, class, init, ...), where
+		// a parent of 0 is none
+		if len(args) >= 3 && args[2] != "0" {
+			s.parents[args[1]] = args[2]
+		}
+	case macro == "ROM_START":
+		if len(args) != 1 {
+			return fmt.Errorf("expected a ROM set name")
+		}
+		s.romSetName, s.romDef, s.region = args[0], RomDefinition{}, nil
+	case macro == "ROM_END":
+		if s.romSetName != "" {
+			s.roms[s.romSetName] = s.romDef
+		}
+		s.romSetName, s.region = "", nil
+	case s.romSetName == "":
+		// macros outside of ROM_START...ROM_END, e.g. in other macros
+	case strings.HasPrefix(macro, "ROM_REGION"):
+		return s.startRegion(args)
+	case s.region == nil:
+		// a region that isn't kept
+	case macro == "ROM_CONTINUE":
+		numbers, err := s.numbers(args, 2)
+		if err != nil {
+			return err
+		}
+		s.region.Operations = append(s.region.Operations, RomRegionOperation{Offset: numbers[0], Length: numbers[1], Type: "continue"})
+	case macro == "ROM_FILL":
+		numbers, err := s.numbers(args, 3)
+		if err != nil {
+			return err
+		}
+		s.region.Operations = append(s.region.Operations, RomRegionOperation{Offset: numbers[0], Length: numbers[1], Type: "fill", FillValue: numbers[2]})
+	case macro == "ROM_RELOAD":
+		numbers, err := s.numbers(args, 2)
+		if err != nil {
+			return err
+		}
+		s.region.Operations = append(s.region.Operations, RomRegionOperation{Offset: numbers[0], Length: numbers[1], Type: "reload"})
+	case macro == "ROM_IGNORE":
+		numbers, err := s.numbers(args, 1)
+		if err != nil {
+			return err
+		}
+		s.region.Operations = append(s.region.Operations, RomRegionOperation{Length: numbers[0], Type: "ignore"})
+	case macro == "ROM_COPY":
+		// copies between regions once they're loaded, rather than from a file
+	default:
+		return s.load(macro, args)
+	}
+	return nil
+}
+
+func (s *mameSource) startRegion(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("expected a size and a tag")
+	}
+	size, err := s.number(args[0])
+	if err != nil {
+		return err
+	}
+	s.region = nil
+	switch strings.Trim(args[1], `"`) {
+	case "maincpu":
+		s.region = &s.romDef.Maincpu
+	case "audiocpu":
+		s.region = &s.romDef.Audiocpu
+	case "qsound":
+		s.region = &s.romDef.Qsound
+	case "gfx":
+		s.region = &s.romDef.Gfx
+	case "key":
+		s.region = &s.romDef.Key
+	default:
+		return nil
+	}
+	s.region.Size = size
+	return nil
+}
+
+func (s *mameSource) load(macro string, args []string) error {
+	layout, ok := loadLayouts[macro]
+	if macro == "ROMX_LOAD" {
+		if len(args) < 5 {
+			return fmt.Errorf("expected 5 arguments, got %d", len(args))
+		}
+		var err error
+		layout, err = s.loadFlags(args[4])
+		if err != nil {
+			return err
+		}
+	} else if !ok {
+		return fmt.Errorf("unknown load macro")
+	}
+	if len(args) < 4 {
+		return fmt.Errorf("expected 4 arguments, got %d", len(args))
+	}
+	numbers, err := s.numbers(args[1:], 2)
+	if err != nil {
+		return err
+	}
+	operation := RomRegionOperation{
+		Offset:    numbers[0],
+		Length:    numbers[1],
+		Type:      "load",
+		GroupSize: layout.groupSize,
+		Skip:      layout.skip,
+		Reverse:   layout.reverse,
+		Filename:  strings.Trim(args[0], `"`),
+	}
+	// NO_DUMP files have no hashes
+	if crc := crcPattern.FindStringSubmatch(args[3]); crc != nil {
+		operation.Crc = strings.ToLower(crc[1])
+	}
+	if sha1 := sha1Pattern.FindStringSubmatch(args[3]); sha1 != nil {
+		operation.Sha1 = strings.ToLower(sha1[1])
+	}
+	s.region.Operations = append(s.region.Operations, operation)
+	return nil
+}
+
+// loadFlags reads the layout of ROMX_LOAD's flags.
+func (s *mameSource) loadFlags(flags string) (loadLayout, error) {
+	layout := loadLayout{groupSize: 1}
+	if strings.Contains(flags, "ROM_GROUPWORD") {
+		layout.groupSize = 2
+	}
+	if strings.Contains(flags, "ROM_GROUPDWORD") {
+		layout.groupSize = 4
+	}
+	if group := groupFlagPattern.FindStringSubmatch(flags); group != nil {
+		n, err := s.number(group[1])
+		if err != nil {
+			return layout, err
+		}
+		layout.groupSize = n
+	}
+	if skip := skipFlagPattern.FindStringSubmatch(flags); skip != nil {
+		n, err := s.number(skip[1])
+		if err != nil {
+			return layout, err
+		}
+		layout.skip = n
+	}
+	layout.reverse = strings.Contains(flags, "ROM_REVERSE")
+	return layout, nil
+}
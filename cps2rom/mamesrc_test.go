@@ -0,0 +1,64 @@
+package cps2rom
+
+import (
+	"reflect"
+	"testing"
+)
+
+// driverExcerpt is shaped like cps2.cpp, but its sets and hashes are made up.
+const driverExcerpt = `
+#define CODE_SIZE 0x100000
+
+ROM_START( hbtest )
+	ROM_REGION( CODE_SIZE, "maincpu", 0 )      /* 68000 code */
+	ROM_LOAD16_WORD_SWAP( "hbt.03", 0x000000, 0x80000, CRC(0123abcd) SHA1(0123456789abcdef0123456789abcdef01234567) )
+	ROM_RELOAD( 0x80000, 0x80000 )
+
+	ROM_REGION( 0x50000, "audiocpu", 0 ) // 64k for the audio CPU (+banks)
+	ROM_LOAD( "hbt.01", 0x00000, 0x08000, CRC(4567cdef) SHA1(89abcdef0123456789abcdef0123456789abcdef) )
+	ROM_IGNORE( 0x08000 )
+	ROM_CONTINUE( 0x10000, 0x18000 )
+	ROM_COPY( "maincpu", 0x00000, 0x48000, 0x8000 )
+
+	ROM_REGION( 0x400000, "qsound", 0 ) // QSound samples
+	ROM_LOAD16_WORD_SWAP( "hbt.11m", 0x000000, 0x400000, CRC(89ab0123) SHA1(fedcba9876543210fedcba9876543210fedcba98) )
+
+	ROM_REGION( 0x1000, "user1", 0 )
+	ROM_COPY( "qsound", 0x00000, 0x0000, 0x1000 )
+ROM_END
+
+GAME( 1993, hbtest, 0, cps2, cps2_2p2b, cps2_state, init_cps2, ROT0, "Homebrew", "Test (931005 World)", MACHINE_SUPPORTS_SAVE )
+`
+
+func TestParseMameDriver(t *testing.T) {
+	roms, err := ParseMameDriver([]byte(driverExcerpt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Roms{"hbtest": {
+		Maincpu: RomRegion{Size: 0x100000, Operations: []RomRegionOperation{
+			{Length: 0x80000, Type: "load", GroupSize: 2, Reverse: true, Filename: "hbt.03", Crc: "0123abcd", Sha1: "0123456789abcdef0123456789abcdef01234567"},
+			{Offset: 0x80000, Length: 0x80000, Type: "reload"},
+		}},
+		Audiocpu: RomRegion{Size: 0x50000, Operations: []RomRegionOperation{
+			{Length: 0x8000, Type: "load", GroupSize: 1, Filename: "hbt.01", Crc: "4567cdef", Sha1: "89abcdef0123456789abcdef0123456789abcdef"},
+			{Length: 0x8000, Type: "ignore"},
+			{Offset: 0x10000, Length: 0x18000, Type: "continue"},
+		}},
+		Qsound: RomRegion{Size: 0x400000, Operations: []RomRegionOperation{
+			{Length: 0x400000, Type: "load", GroupSize: 2, Reverse: true, Filename: "hbt.11m", Crc: "89ab0123", Sha1: "fedcba9876543210fedcba9876543210fedcba98"},
+		}},
+	}}
+	if !reflect.DeepEqual(roms, want) {
+		t.Fatalf("parsed\n%+v\nexpected\n%+v", roms, want)
+	}
+	if err := roms["hbtest"].Validate(); err != nil {
+		t.Error(err)
+	}
+	files := roms["hbtest"].Files()
+	for filename, size := range map[string]int{"hbt.03": 0x80000, "hbt.01": 0x28000, "hbt.11m": 0x400000} {
+		if files[filename].Size != size {
+			t.Errorf("%s is 0x%x bytes, expected 0x%x", filename, files[filename].Size, size)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package cps2rom
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Definitions of ROM sets MAME doesn't have, like homebrew and prototypes, or
+// corrections to those that it does, are read from overlays: .json files in
+// the same shape as roms.json. An overlay's definitions replace built in ones
+// of the same name whole.
+
+var (
+	crcFormat  = regexp.MustCompile(`^[0-9a-fA-F]{8}$`)
+	sha1Format = regexp.MustCompile(`^[0-9a-fA-F]{40}$`)
+)
+
+// OverlayDirectory returns the directory overlays are read from by default,
+// mbdcps2/roms in the user's configuration directory.
+func OverlayDirectory() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "mbdcps2", "roms"), nil
+}
+
+// LoadRomOverlays reads the overlay at path, or every .json overlay in it if
+// it's a directory, in name order, and merges their definitions into
+// RomDefinitions. It returns the names of the ROM sets defined.
+func LoadRomOverlays(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	overlayPaths := []string{path}
+	if info.IsDir() {
+		overlayPaths, err = filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		slices.Sort(overlayPaths)
+	}
+	var romSetNames []string
+	for _, overlayPath := range overlayPaths {
+		overlay, err := os.ReadFile(overlayPath)
+		if err != nil {
+			return nil, err
+		}
+		names, err := LoadRomOverlay(overlay)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Clean(overlayPath), err)
+		}
+		romSetNames = append(romSetNames, names...)
+	}
+	return romSetNames, nil
+}
+
+// LoadRomOverlay validates the definitions of an overlay and merges them into
+// RomDefinitions. Nothing is merged if any of them is invalid.
+func LoadRomOverlay(overlay []byte) ([]string, error) {
+	var roms Roms
+	decoder := json.NewDecoder(bytes.NewReader(overlay))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&roms); err != nil {
+		return nil, err
+	}
+	if RomDefinitions == nil {
+		RomDefinitions = &Roms{}
+	}
+	romSetNames := make([]string, 0, len(roms))
+	for romSetName, romDef := range roms {
+		if err := romDef.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", romSetName, err)
+		}
+		if romDef.Parent != "" {
+			_, isInOverlay := roms[romDef.Parent]
+			_, isBuiltIn := (*RomDefinitions)[romDef.Parent]
+			if romDef.Parent == romSetName || !isInOverlay && !isBuiltIn {
+				return nil, fmt.Errorf("%s: unknown parent %s", romSetName, romDef.Parent)
+			}
+		}
+		romSetNames = append(romSetNames, romSetName)
+	}
+	for romSetName, romDef := range roms {
+		(*RomDefinitions)[romSetName] = romDef
+	}
+	slices.Sort(romSetNames)
+	return romSetNames, nil
+}
+
+// Validate checks that romDef loads a maincpu, that its operations are ones
+// ProcessRegionFromZip knows and stay inside their regions, and that its
+// hashes look like hashes.
+func (romDef RomDefinition) Validate() error {
+	if len(romDef.Maincpu.Operations) == 0 {
+		return fmt.Errorf("maincpu has no operations")
+	}
+	for i, region := range romDef.Regions() {
+		var load RomRegionOperation
+		for j, operation := range region.Operations {
+			if strings.ToLower(operation.Type) == "load" {
+				load = operation
+			}
+			if err := operation.validate(region.Size, load); err != nil {
+				return fmt.Errorf("%s operation %d: %w", RegionNames[i], j, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validate checks operation, whose region is regionSize bytes. load is the
+// last load up to and including it, whose layout a continue or reload carries
+// on with.
+func (operation RomRegionOperation) validate(regionSize int, load RomRegionOperation) error {
+	if operation.Offset < 0 || operation.Length <= 0 {
+		return fmt.Errorf("invalid offset 0x%x or length 0x%x", operation.Offset, operation.Length)
+	}
+	end := operation.Offset + operation.Length
+	switch strings.ToLower(operation.Type) {
+	case "load":
+		if operation.Filename == "" {
+			return fmt.Errorf("load has no filename")
+		}
+		if operation.GroupSize < 0 || operation.Skip < 0 {
+			return fmt.Errorf("%s: invalid groupSize %d or skip %d", operation.Filename, operation.GroupSize, operation.Skip)
+		}
+		end = regionIndex(operation, operation.Offset, operation.Length-1) + 1
+		if operation.Crc != "" && !crcFormat.MatchString(operation.Crc) {
+			return fmt.Errorf("%s: crc %s isn't 8 hex digits", operation.Filename, operation.Crc)
+		}
+		if operation.Sha1 != "" && !sha1Format.MatchString(operation.Sha1) {
+			return fmt.Errorf("%s: sha1 %s isn't 40 hex digits", operation.Filename, operation.Sha1)
+		}
+	case "continue", "reload":
+		if load.Filename == "" {
+			return fmt.Errorf("%s without a load before it", operation.Type)
+		}
+		end = regionIndex(load, operation.Offset, operation.Length-1) + 1
+	case "ignore":
+		if load.Filename == "" {
+			return fmt.Errorf("ignore without a load before it")
+		}
+		// skips bytes of the file, rather than loading them into the region
+		end = 0
+	case "fill":
+	default:
+		return fmt.Errorf("unknown type %q", operation.Type)
+	}
+	if end > regionSize {
+		return fmt.Errorf("0x%x-0x%x is outside the 0x%x byte region", operation.Offset, end, regionSize)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package cps2rom
+
+import "testing"
+
+func TestRomDefinitionValidate(t *testing.T) {
+	maincpu := RomRegion{Size: 0x10, Operations: []RomRegionOperation{{Length: 0x10, Type: "load", Filename: "hb.03"}}}
+	// each load and continue spreads 0x10 bytes over 0x3a in 2 byte groups
+	interleaved := []RomRegionOperation{
+		{Length: 0x10, Type: "load", GroupSize: 2, Skip: 6, Filename: "hb.13m"},
+		{Offset: 0x40, Length: 0x10, Type: "continue"},
+	}
+	tests := []struct {
+		name    string
+		gfx     RomRegion
+		isError bool
+	}{
+		{name: "interleaved continue inside the region", gfx: RomRegion{Size: 0x7a, Operations: interleaved}},
+		{name: "interleaved continue past the region", gfx: RomRegion{Size: 0x60, Operations: interleaved}, isError: true},
+		{name: "continue without a load", gfx: RomRegion{Size: 0x10, Operations: []RomRegionOperation{{Length: 0x10, Type: "continue"}}}, isError: true},
+		{name: "fill", gfx: RomRegion{Size: 0x10, Operations: []RomRegionOperation{{Length: 0x10, Type: "fill", FillValue: 0xff}}}},
+		{name: "unknown type", gfx: RomRegion{Size: 0x10, Operations: []RomRegionOperation{{Length: 0x10, Type: "copy"}}}, isError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := RomDefinition{Maincpu: maincpu, Gfx: tt.gfx}.Validate()
+			if tt.isError && err == nil {
+				t.Error("Validate didn't fail")
+			} else if !tt.isError && err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package cps2rom
+
+import (
+	"archive/zip"
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"github.com/MBDesu/mbdcps2/Resources"
+	file_utils "github.com/MBDesu/mbdcps2/utils"
+)
+
+// Like MAME, a clone is a ROM set that shares some of its files with a
+// parent. Its files are stored in one of three layouts: split, where the
+// clone's .zip only has the files it doesn't share; merged, where there's no
+// clone .zip and the parent's .zip has the files of its clones too; and
+// non-merged, where every .zip has all the files of its set.
+
+const (
+	LayoutSplit     = "split"
+	LayoutMerged    = "merged"
+	LayoutNonMerged = "nonmerged"
+)
+
+var Layouts = []string{LayoutSplit, LayoutMerged, LayoutNonMerged}
+
+// Clones returns the names of the ROM sets whose parent is romSetName.
+func Clones(romSetName string) []string {
+	var clones []string
+	for cloneName, romDef := range *RomDefinitions {
+		if romDef.Parent == romSetName {
+			clones = append(clones, cloneName)
+		}
+	}
+	slices.Sort(clones)
+	return clones
+}
+
+// cloneFilenames returns the names of the files of romSetName's clones, which
+// a merged set has too.
+func cloneFilenames(romSetName string) map[string]bool {
+	filenames := map[string]bool{}
+	for _, cloneName := range Clones(romSetName) {
+		for filename := range GetRomDefinition(cloneName).Files() {
+			filenames[filename] = true
+		}
+	}
+	return filenames
+}
+
+// SharedFiles returns the files romDef shares with its parent, those its
+// parent has too, at the same size.
+func (romDef RomDefinition) SharedFiles() map[string]RomFile {
+	shared := map[string]RomFile{}
+	parent, ok := (*RomDefinitions)[romDef.Parent]
+	if romDef.Parent == "" || !ok {
+		return shared
+	}
+	parentFiles := parent.Files()
+	for filename, file := range romDef.Files() {
+		if parentFile, ok := parentFiles[filename]; ok && parentFile.Size == file.Size {
+			shared[filename] = file
+		}
+	}
+	return shared
+}
+
+// openSibling opens the .zip or directory of ROM set romSetName in directory,
+// or returns nil if there isn't one.
+func openSibling(directory string, romSetName string) (*RomSource, error) {
+	if romSetName == "" {
+		return nil, nil
+	}
+	romSetPath := FindRomSetPath(directory, romSetName)
+	if romSetPath == "" {
+		return nil, nil
+	}
+	return OpenRomSource(romSetPath)
+}
+
+// findSourceFile returns the first file named filename in sources, or nil.
+func findSourceFile(filename string, sources ...*RomSource) *SourceFile {
+	for _, s := range sources {
+		if s == nil {
+			continue
+		}
+		if file := s.Find(filename); file != nil {
+			return file
+		}
+	}
+	return nil
+}
+
+// AddParentFiles adds the files romSource is missing that romDef shares with
+// its parent to romSource.File, from the parent's .zip or directory next to
+// it, so a split clone reads like a non-merged one. The parent is closed with
+// romSource, and WriteModifiedRegionsToZip leaves its files out unless they're
+// modified. It returns how many files it added.
+func AddParentFiles(romSource *RomSource, romDef RomDefinition) (int, error) {
+	var missing []string
+	for filename := range romDef.SharedFiles() {
+		if romSource.Find(filename) == nil {
+			missing = append(missing, filename)
+		}
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+	parent, err := openSibling(filepath.Dir(romSource.Path), romDef.Parent)
+	if err != nil || parent == nil {
+		return 0, err
+	}
+	slices.Sort(missing)
+	added := 0
+	for _, filename := range missing {
+		if file := parent.Find(filename); file != nil {
+			parentFile := *file
+			parentFile.fromParent = true
+			romSource.File = append(romSource.File, &parentFile)
+			added++
+		}
+	}
+	if added == 0 {
+		return 0, parent.Close()
+	}
+	romSource.closers = append(romSource.closers, parent)
+	Resources.Logger.Info(fmt.Sprintf("Using %d files from parent ROM set %s", added, romDef.Parent))
+	return added, nil
+}
+
+// ConvertRomZip writes the ROM set romSetName in the .zip or directory at
+// zipFilepath to a .zip at outputFilepath in layout. Files it doesn't have are
+// looked for in the .zips or directories of the parent and, for a merged
+// layout, the clones next to it. A merged .zip is
+// always of the parent, with the unique files of every clone that can be
+// found; clones whose files can't be are left out.
+func ConvertRomZip(romSetName string, zipFilepath string, layout string, outputFilepath string) error {
+	romDef, ok := (*RomDefinitions)[romSetName]
+	if !ok {
+		return fmt.Errorf("ROM set %s is invalid or unsupported", romSetName)
+	}
+	if !slices.Contains(Layouts, layout) {
+		return fmt.Errorf("unknown layout %s", layout)
+	}
+	romZip, err := OpenRomSource(zipFilepath)
+	if err != nil {
+		return err
+	}
+	defer romZip.Close()
+	directory := filepath.Dir(romZip.Path)
+	parentZip, err := openSibling(directory, romDef.Parent)
+	if err != nil {
+		return err
+	}
+	if parentZip != nil {
+		defer parentZip.Close()
+	}
+	files := map[string]*SourceFile{}
+	var missing []string
+	// adds the files of a set from sources, or the names of those missing
+	add := func(filenames map[string]RomFile, sources ...*RomSource) {
+		for filename := range filenames {
+			if file := findSourceFile(filename, sources...); file != nil {
+				files[filename] = file
+			} else {
+				missing = append(missing, filename)
+			}
+		}
+	}
+	switch layout {
+	case LayoutNonMerged:
+		add(romDef.Files(), romZip, parentZip)
+	case LayoutSplit:
+		unique := romDef.Files()
+		for filename := range romDef.SharedFiles() {
+			delete(unique, filename)
+		}
+		add(unique, romZip, parentZip)
+	case LayoutMerged:
+		if romDef.Parent != "" {
+			Resources.Logger.Info(fmt.Sprintf("%s is a clone, writing its parent %s", romSetName, romDef.Parent))
+			romSetName, romDef = romDef.Parent, (*RomDefinitions)[romDef.Parent]
+		}
+		add(romDef.Files(), romZip, parentZip)
+		err = addCloneFiles(files, romSetName, directory, romZip)
+	}
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		return &MissingFilesError{missing}
+	}
+	return writeZipFiles(outputFilepath, files)
+}
+
+// addCloneFiles adds the unique files of each clone of romSetName to files,
+// from the clone's .zip or directory in directory, or mergedZip. Clones that
+// share a file name must have the same file.
+func addCloneFiles(files map[string]*SourceFile, romSetName string, directory string, mergedZip *RomSource) error {
+	for _, cloneName := range Clones(romSetName) {
+		cloneDef := (*RomDefinitions)[cloneName]
+		cloneZip, err := openSibling(directory, cloneName)
+		if err != nil {
+			return err
+		}
+		if cloneZip != nil {
+			defer cloneZip.Close()
+		}
+		shared := cloneDef.SharedFiles()
+		cloneFiles := map[string]*SourceFile{}
+		missing := 0
+		for filename := range cloneDef.Files() {
+			if _, ok := shared[filename]; ok {
+				continue
+			}
+			file := findSourceFile(filename, cloneZip, mergedZip)
+			if file == nil {
+				missing++
+				continue
+			}
+			cloneFiles[filename] = file
+		}
+		if missing > 0 {
+			Resources.Logger.Warn(fmt.Sprintf("Leaving out clone %s, missing %d files", cloneName, missing))
+			continue
+		}
+		for filename, file := range cloneFiles {
+			if existing, ok := files[filename]; ok {
+				isSame, err := isSameFile(existing, file)
+				if err != nil {
+					return err
+				}
+				if !isSame {
+					return fmt.Errorf("clone %s has a different %s than another set in the merged .zip", cloneName, filename)
+				}
+			}
+			files[filename] = file
+		}
+	}
+	return nil
+}
+
+func isSameFile(a *SourceFile, b *SourceFile) (bool, error) {
+	if a.Size != b.Size {
+		return false, nil
+	}
+	aCrc, err := a.CRC32()
+	if err != nil {
+		return false, err
+	}
+	bCrc, err := b.CRC32()
+	return aCrc == bCrc, err
+}
+
+// writeZipFiles writes files to a new .zip at outputFilepath, by name.
+func writeZipFiles(outputFilepath string, files map[string]*SourceFile) error {
+	f, err := file_utils.CreateFile(outputFilepath)
+	if err != nil {
+		return err
+	}
+	w := zip.NewWriter(f)
+	filenames := make([]string, 0, len(files))
+	for filename := range files {
+		filenames = append(filenames, filename)
+	}
+	slices.Sort(filenames)
+	for _, filename := range filenames {
+		Resources.Logger.Info(fmt.Sprintf("Writing %s...", filename))
+		if err := copySourceFileToNewZip(files[filename], w); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
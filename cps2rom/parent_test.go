@@ -0,0 +1,93 @@
+package cps2rom
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeSplitClone writes parent ROM set hb and its split clone hbc as
+// directories side by side, and opens the clone with the parent's files.
+func writeSplitClone(t *testing.T) *RomSource {
+	t.Helper()
+	useRomDefinitions(t, Roms{
+		"hb": {
+			Maincpu: RomRegion{Size: 8, Operations: []RomRegionOperation{{Length: 8, Type: "load", Filename: "hb.03"}}},
+			Gfx:     RomRegion{Size: 8, Operations: []RomRegionOperation{{Length: 8, Type: "load", Filename: "hb.13m"}}},
+		},
+		"hbc": {
+			Parent:  "hb",
+			Maincpu: RomRegion{Size: 8, Operations: []RomRegionOperation{{Length: 8, Type: "load", Filename: "hbc.03"}}},
+			Gfx:     RomRegion{Size: 8, Operations: []RomRegionOperation{{Length: 8, Type: "load", Filename: "hb.13m"}}},
+		},
+	})
+	dir := t.TempDir()
+	for _, file := range []struct {
+		path string
+		seed uint64
+	}{{"hb/hb.03", 1}, {"hb/hb.13m", 2}, {"hbc/hbc.03", 3}} {
+		path := filepath.Join(dir, filepath.FromSlash(file.path))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, randomBytes(file.seed, 8), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	romSource, err := OpenRomSource(filepath.Join(dir, "hbc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { romSource.Close() })
+	added, err := AddParentFiles(romSource, (*RomDefinitions)["hbc"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 1 {
+		t.Fatalf("added %d parent files, expected 1", added)
+	}
+	return romSource
+}
+
+func zipFilenames(t *testing.T, zipFilepath string) []string {
+	t.Helper()
+	r, err := zip.OpenReader(zipFilepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var filenames []string
+	for _, file := range r.File {
+		filenames = append(filenames, file.Name)
+	}
+	slices.Sort(filenames)
+	return filenames
+}
+
+func TestWriteModifiedRegionToZipLeavesOutParentFiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		region   string
+		modified map[string][]byte
+		want     []string
+	}{
+		{name: "clone's region", region: "maincpu", modified: map[string][]byte{"hbc.03": randomBytes(4, 8)}, want: []string{"hbc.03"}},
+		{name: "parent's region unmodified", region: "gfx", modified: map[string][]byte{"hb.13m": randomBytes(2, 8)}, want: []string{"hbc.03"}},
+		{name: "parent's region modified", region: "gfx", modified: map[string][]byte{"hb.13m": randomBytes(5, 8)}, want: []string{"hb.13m", "hbc.03"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			romSource := writeSplitClone(t)
+			region, _ := (*RomDefinitions)["hbc"].Region(tt.region)
+			outputFilepath := filepath.Join(t.TempDir(), "hbc.zip")
+			if err := WriteModifiedRegionToZip(outputFilepath, romSource, writeRomDir(t, tt.modified), region); err != nil {
+				t.Fatal(err)
+			}
+			if got := zipFilenames(t, outputFilepath); !slices.Equal(got, tt.want) {
+				t.Errorf("wrote %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
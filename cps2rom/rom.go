@@ -4,7 +4,6 @@ import (
 	"archive/zip"
 	"errors"
 	"fmt"
-	"io"
 	"path/filepath"
 	"strings"
 
@@ -12,33 +11,17 @@ import (
 	file_utils "github.com/MBDesu/mbdcps2/utils"
 )
 
+// SplitRegionToFiles writes the files SplitRegion takes out of binary, a
+// region as ProcessRegionFromZip returns it, to a .zip at zipPath.
 func SplitRegionToFiles(romRegion RomRegion, binary []byte, zipPath string) error {
-	f, err := file_utils.CreateFile(zipPath)
+	files, err := SplitRegion(romRegion, binary)
 	if err != nil {
 		return err
 	}
-	w := zip.NewWriter(f)
-	for _, operation := range romRegion.Operations {
-		Resources.Logger.Info(fmt.Sprintf("Writing %s from 0x%06x to 0x%06x...", operation.Filename, operation.Offset, operation.Offset+operation.Length))
-		regionBytes := binary[operation.Offset : operation.Offset+operation.Length]
-		fr, err := w.Create(operation.Filename)
-		if err != nil {
-			return err
-		}
-		_, err = fr.Write(regionBytes)
-		if err != nil {
-			return err
-		}
-	}
-	err = w.Close()
-	if err != nil {
-		return err
-	}
-	err = f.Close()
-	return err
+	return writeZipContents(zipPath, files)
 }
 
-func ValidateRomZip(romDefinition RomDefinition, zip *zip.ReadCloser) error {
+func ValidateRomZip(romDefinition RomDefinition, romSource *RomSource) error {
 	var numFiles = len(romDefinition.Maincpu.Operations) + len(romDefinition.Audiocpu.Operations) + len(romDefinition.Gfx.Operations) + len(romDefinition.Qsound.Operations) + len(romDefinition.Key.Operations)
 	regions := []RomRegion{romDefinition.Audiocpu, romDefinition.Gfx, romDefinition.Maincpu, romDefinition.Qsound, romDefinition.Key}
 	requiredFiles := make([]string, 0, numFiles)
@@ -57,7 +40,7 @@ func ValidateRomZip(romDefinition RomDefinition, zip *zip.ReadCloser) error {
 	for _, filename := range requiredFiles {
 		hasFiles[filename] = false
 	}
-	for _, file := range zip.File {
+	for _, file := range romSource.File {
 		var name = file.Name
 		_, ok := hasFiles[name] // using extance of the key
 		if ok {
@@ -74,106 +57,173 @@ func ValidateRomZip(romDefinition RomDefinition, zip *zip.ReadCloser) error {
 		}
 	}
 	if numMissingFiles > 0 {
-		logString := fmt.Sprintf("missing %d files:\n", numMissingFiles)
-		for _, missingFile := range missingFiles {
-			logString += "    " + Resources.LogText.Bold(missingFile) + "\n"
-		}
-		return fmt.Errorf(logString)
+		return &MissingFilesError{missingFiles}
 	}
 
 	return nil
 }
 
-func ProcessRegionFromZip(romZip *zip.ReadCloser, region RomRegion) ([]uint8, error) {
+type MissingFilesError struct {
+	Files []string
+}
+
+func (e *MissingFilesError) Error() string {
+	logString := fmt.Sprintf("missing %d files:\n", len(e.Files))
+	for _, missingFile := range e.Files {
+		logString += "    " + Resources.LogText.Bold(missingFile) + "\n"
+	}
+	return logString
+}
+
+func ProcessRegionFromZip(romSource *RomSource, region RomRegion) ([]uint8, error) {
 	Resources.Logger.Warn("Processing binary...")
 	regionBinary := make([]uint8, region.Size)
+	var missingFiles []string
+	// the last load, whose file continue operations carry on loading
+	var load RomRegionOperation
+	var p []uint8
+	filePtr := 0
 
 	for _, operation := range region.Operations {
-		var bufPtr = operation.Offset
-
-		// TODO: implement other operations
 		switch strings.ToLower(operation.Type) {
 		case "fill":
-			for range operation.Length {
-				regionBinary[bufPtr] = uint8(operation.FillValue & 0xff)
+			if operation.Offset < 0 || operation.Offset+operation.Length > len(regionBinary) {
+				return nil, fmt.Errorf("fill of 0x%x bytes at 0x%06x is outside the region", operation.Length, operation.Offset)
+			}
+			for i := range operation.Length {
+				regionBinary[operation.Offset+i] = uint8(operation.FillValue & 0xff)
 			}
 			continue
 		case "continue":
-			bufPtr += operation.Length
+			if p == nil {
+				continue
+			}
+		case "reload":
+			if p == nil {
+				continue
+			}
+			filePtr = 0
+		case "ignore":
+			filePtr += operation.Length
 			continue
+		case "load":
+			operationFile := romSource.Find(operation.Filename)
+			if operationFile == nil {
+				missingFiles = append(missingFiles, operation.Filename)
+				p = nil
+				continue
+			}
+			var err error
+			p, err = operationFile.ReadAll()
+			if err != nil {
+				return nil, err
+			}
+			load, filePtr = operation, 0
+			Resources.Logger.Info(fmt.Sprintf("Processing %s, starting at offset +0x%06X", operation.Filename, operation.Offset))
 		default:
-			break
-		}
-		if operation.Type != strings.ToLower("load") {
 			continue
 		}
-		var operationFile *zip.File
-		for _, file := range romZip.File {
-			if operation.Filename == filepath.Base(file.Name) {
-				operationFile = file
-				break
+		if filePtr+operation.Length > len(p) {
+			return nil, fmt.Errorf("%s is 0x%x bytes, expected at least 0x%x", load.Filename, len(p), filePtr+operation.Length)
+		}
+		for i, b := range p[filePtr : filePtr+operation.Length] {
+			j := regionIndex(load, operation.Offset, i)
+			if j >= len(regionBinary) {
+				return nil, fmt.Errorf("%s doesn't fit in the region at 0x%06x", load.Filename, operation.Offset)
 			}
+			regionBinary[j] = b
 		}
-		if operationFile != nil {
-			r, err := operationFile.Open()
-			if err != nil {
-				return nil, err
+		filePtr += operation.Length
+	}
+	if len(missingFiles) > 0 {
+		return nil, &MissingFilesError{missingFiles}
+	}
+	Resources.Logger.Done("Done processing binary!")
+	return regionBinary, nil
+}
+
+// regionIndex returns where the ith byte a load, or a continue of it, reads
+// from its file goes in the region, from offset. Files load in groups of
+// GroupSize bytes, each reversed if Reverse, with Skip bytes between them.
+func regionIndex(load RomRegionOperation, offset int, i int) int {
+	groupSize := max(load.GroupSize, 1)
+	group, k := i/groupSize, i%groupSize
+	if load.Reverse {
+		k = groupSize - 1 - k
+	}
+	return offset + group*(groupSize+load.Skip) + k
+}
+
+// SplitRegion takes the files region loads back out of regionBinary, undoing
+// ProcessRegionFromZip. Fills are left out, and files with ignored bytes can't
+// be split.
+func SplitRegion(region RomRegion, regionBinary []uint8) (map[string][]uint8, error) {
+	files := map[string][]uint8{}
+	var load RomRegionOperation
+	filePtr := 0
+	for _, operation := range region.Operations {
+		switch strings.ToLower(operation.Type) {
+		case "load":
+			load, filePtr = operation, 0
+		case "continue":
+			if load.Filename == "" {
+				continue
 			}
-			p, err := io.ReadAll(r)
-			if err != nil {
-				return nil, err
+		case "reload":
+			if load.Filename == "" {
+				continue
 			}
-			defer r.Close()
-			bytesLeft := operation.Length
-			skip := operation.Skip + operation.GroupSize
-			Resources.Logger.Info(fmt.Sprintf("Processing %s, starting at offset +0x%06X", operation.Filename, bufPtr))
-
-			if (operation.GroupSize == 1 || !operation.Reverse) && operation.Skip == 0 {
-				for j := range bytesLeft {
-					regionBinary[bufPtr] = p[j]
-					bufPtr++
-				}
-			} else if operation.GroupSize == 1 {
-				for j := range bytesLeft {
-					regionBinary[bufPtr] = p[j]
-					bufPtr++
-				}
-			} else if !operation.Reverse {
-				for j := 0; j < operation.Length && bytesLeft > 0; j++ {
-					// fmt.Printf("bufPtr = %06x\n", bufPtr)
-					for k := 0; k < operation.GroupSize && bytesLeft > 0; k++ {
-						// fmt.Printf("%06x\n", k+bufPtr)
-						regionBinary[k+bufPtr] = p[j+k]
-						bytesLeft--
-					}
-					bufPtr += skip
-				}
-			} else {
-				bytesWritten := 0
-				for bytesWritten < operation.Length {
-					for j := operation.GroupSize - 1; j >= 0; j-- {
-						regionBinary[bufPtr+j] = p[bytesWritten]
-						bytesWritten++
-					}
-					bufPtr += skip
-				}
+			filePtr = 0
+		case "ignore":
+			if load.Filename != "" {
+				return nil, fmt.Errorf("%s has 0x%x bytes that aren't loaded", load.Filename, operation.Length)
+			}
+			continue
+		default:
+			continue
+		}
+		p := make([]uint8, operation.Length)
+		for i := range p {
+			j := regionIndex(load, operation.Offset, i)
+			if j >= len(regionBinary) {
+				return nil, fmt.Errorf("%s doesn't fit in the region at 0x%06x", load.Filename, operation.Offset)
 			}
+			p[i] = regionBinary[j]
 		}
+		// a reload reads again what's already been split out
+		if loaded := len(files[load.Filename]) - filePtr; loaded < len(p) {
+			files[load.Filename] = append(files[load.Filename], p[max(loaded, 0):]...)
+		}
+		filePtr += operation.Length
 	}
-	Resources.Logger.Done("Done processing binary!")
-	return regionBinary, nil
+	return files, nil
 }
 
-func ParseRomZip(file_path string, romSetName string) (*zip.ReadCloser, *RomDefinition, error) {
+// ParseRomZip opens the ROM .zip or directory at file_path and checks it has
+// every file of romSetName, which it identifies if it's "".
+func ParseRomZip(file_path string, romSetName string) (*RomSource, *RomDefinition, error) {
 	Resources.Logger.Warn(fmt.Sprintf("Parsing %s...", filepath.Clean(file_path)))
-	romZipFile, err := file_utils.GetZipFileReader(file_path)
+	romZipFile, err := OpenRomSource(file_path)
 	if err != nil {
 		return nil, nil, err
 	}
+	if romSetName == "" {
+		romSetName, err = IdentifyRomSet(romZipFile, file_path)
+		if err != nil {
+			romZipFile.Close()
+			return nil, nil, err
+		}
+	}
 	romDef, ok := (*RomDefinitions)[romSetName]
 	if !ok {
+		romZipFile.Close()
 		return nil, nil, errors.New(fmt.Sprintf("ROM set %s is invalid or unsupported", romSetName))
 	}
+	_, err = AddParentFiles(romZipFile, romDef)
+	if err != nil {
+		romZipFile.Close()
+		return nil, nil, err
+	}
 	err = ValidateRomZip(romDef, romZipFile)
 	if err == nil {
 		Resources.Logger.Done("ROM OK")
@@ -181,35 +231,25 @@ func ParseRomZip(file_path string, romSetName string) (*zip.ReadCloser, *RomDefi
 	return romZipFile, &romDef, err
 }
 
-func copyZippedFileToNewZip(file *zip.File, newZip *zip.Writer) error {
-	romFile, err := file.Open()
-	if err != nil {
-		return err
-	}
-	header, err := zip.FileInfoHeader(file.FileInfo())
-	if err != nil {
-		return err
-	}
-	header.Name = file.Name
-	newZippedFile, err := newZip.CreateHeader(header)
-	if err != nil {
-		return err
-	}
-	_, err = io.Copy(newZippedFile, romFile)
-	if err != nil {
-		return err
-	}
-	return err
+func WriteModifiedRegionToZip(outputFilepath string, romZip *RomSource, modifiedRegionZip *RomSource, region RomRegion) error {
+	return WriteModifiedRegionsToZip(outputFilepath, romZip, []*RomSource{modifiedRegionZip}, []RomRegion{region})
 }
 
-func WriteModifiedRegionToZip(outputFilepath string, romZip *zip.ReadCloser, modifiedRegionZip *zip.ReadCloser, region RomRegion) error {
+// WriteModifiedRegionsToZip copies romZip to a .zip at outputFilepath,
+// replacing the files of each region with those in the matching
+// modifiedRegionZips entry. Files AddParentFiles added from a split clone's
+// parent are left out, as are modified files that are the same as those, so
+// the .zip stays split.
+func WriteModifiedRegionsToZip(outputFilepath string, romZip *RomSource, modifiedRegionZips []*RomSource, regions []RomRegion) error {
 	f, err := file_utils.CreateFile(outputFilepath)
 	if err != nil {
 		return err
 	}
-	excludedRegionFilenames := make([]string, len(region.Operations))
-	for i, operation := range region.Operations {
-		excludedRegionFilenames[i] = operation.Filename
+	excludedRegionFilenames := make([]string, 0)
+	for _, region := range regions {
+		for _, operation := range region.Operations {
+			excludedRegionFilenames = append(excludedRegionFilenames, operation.Filename)
+		}
 	}
 	newZip := zip.NewWriter(f)
 	for _, file := range romZip.File {
@@ -220,18 +260,29 @@ func WriteModifiedRegionToZip(outputFilepath string, romZip *zip.ReadCloser, mod
 				break
 			}
 		}
-		if isInExcludedRegion {
+		if isInExcludedRegion || file.fromParent {
 			continue
 		}
-		err = copyZippedFileToNewZip(file, newZip)
+		err = copySourceFileToNewZip(file, newZip)
 		if err != nil {
 			return err
 		}
 	}
-	for _, file := range modifiedRegionZip.File {
-		err = copyZippedFileToNewZip(file, newZip)
-		if err != nil {
-			return err
+	for _, modifiedRegionZip := range modifiedRegionZips {
+		for _, file := range modifiedRegionZip.File {
+			if parentFile := romZip.Find(file.Name); parentFile != nil && parentFile.fromParent {
+				isSame, err := isSameFile(parentFile, file)
+				if err != nil {
+					return err
+				}
+				if isSame {
+					continue
+				}
+			}
+			err = copySourceFileToNewZip(file, newZip)
+			if err != nil {
+				return err
+			}
 		}
 	}
 	newZip.Close()
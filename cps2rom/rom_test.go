@@ -0,0 +1,161 @@
+package cps2rom
+
+import (
+	"bytes"
+	"testing"
+)
+
+// sequence returns length bytes counting up from first.
+func sequence(first uint8, length int) []byte {
+	contents := make([]byte, length)
+	for i := range contents {
+		contents[i] = first + uint8(i)
+	}
+	return contents
+}
+
+func TestProcessRegionFromZip(t *testing.T) {
+	files := map[string][]byte{
+		"t.13m": sequence(0x00, 8),
+		"t.15m": sequence(0x40, 8),
+		"t.17m": sequence(0x80, 8),
+		"t.19m": sequence(0xc0, 8),
+		"t.03":  sequence(0x10, 8),
+	}
+	tests := []struct {
+		name   string
+		region RomRegion
+		want   []byte
+	}{
+		{
+			// ROM_LOAD64_WORD, as the gfx loads. Groups used to start at
+			// every byte of the file, rather than every GroupSize bytes.
+			name: "groups of words",
+			region: RomRegion{Size: 0x20, Operations: []RomRegionOperation{
+				{Offset: 0, Length: 8, Type: "load", GroupSize: 2, Skip: 6, Filename: "t.13m"},
+				{Offset: 2, Length: 8, Type: "load", GroupSize: 2, Skip: 6, Filename: "t.15m"},
+				{Offset: 4, Length: 8, Type: "load", GroupSize: 2, Skip: 6, Filename: "t.17m"},
+				{Offset: 6, Length: 8, Type: "load", GroupSize: 2, Skip: 6, Filename: "t.19m"},
+			}},
+			want: []byte{
+				0x00, 0x01, 0x40, 0x41, 0x80, 0x81, 0xc0, 0xc1,
+				0x02, 0x03, 0x42, 0x43, 0x82, 0x83, 0xc2, 0xc3,
+				0x04, 0x05, 0x44, 0x45, 0x84, 0x85, 0xc4, 0xc5,
+				0x06, 0x07, 0x46, 0x47, 0x86, 0x87, 0xc6, 0xc7,
+			},
+		},
+		{
+			// ROM_LOAD16_WORD_SWAP, as maincpu loads
+			name: "reversed words",
+			region: RomRegion{Size: 0x8, Operations: []RomRegionOperation{
+				{Offset: 0, Length: 8, Type: "load", GroupSize: 2, Reverse: true, Filename: "t.03"},
+			}},
+			want: []byte{0x11, 0x10, 0x13, 0x12, 0x15, 0x14, 0x17, 0x16},
+		},
+		{
+			name: "bytes",
+			region: RomRegion{Size: 0xc, Operations: []RomRegionOperation{
+				{Offset: 2, Length: 8, Type: "load", Filename: "t.03"},
+			}},
+			want: []byte{0x00, 0x00, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x00, 0x00},
+		},
+		{
+			name: "reload",
+			region: RomRegion{Size: 0xc, Operations: []RomRegionOperation{
+				{Offset: 0, Length: 4, Type: "load", Filename: "t.03"},
+				{Offset: 8, Length: 4, Type: "reload"},
+			}},
+			want: []byte{0x10, 0x11, 0x12, 0x13, 0x00, 0x00, 0x00, 0x00, 0x10, 0x11, 0x12, 0x13},
+		},
+		{
+			name: "ignore",
+			region: RomRegion{Size: 0x6, Operations: []RomRegionOperation{
+				{Offset: 0, Length: 2, Type: "load", Filename: "t.03"},
+				{Length: 2, Type: "ignore"},
+				{Offset: 4, Length: 2, Type: "continue"},
+			}},
+			want: []byte{0x10, 0x11, 0x00, 0x00, 0x14, 0x15},
+		},
+	}
+	romSource := writeRomDir(t, files)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ProcessRegionFromZip(romSource, tt.region)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("region is\n% x\nexpected\n% x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitRegion(t *testing.T) {
+	files := map[string][]byte{
+		"t.03": randomBytes(5, 8),
+		"t.04": randomBytes(6, 8),
+		"t.05": randomBytes(7, 16),
+		"t.06": randomBytes(8, 4),
+		"t.07": randomBytes(9, 8),
+	}
+	region := RomRegion{Size: 0x40, Operations: []RomRegionOperation{
+		{Offset: 0x00, Length: 8, Type: "load", GroupSize: 2, Skip: 2, Reverse: true, Filename: "t.03"},
+		{Offset: 0x02, Length: 8, Type: "load", GroupSize: 2, Skip: 2, Filename: "t.04"},
+		{Offset: 0x10, Length: 8, Type: "load", Filename: "t.05"},
+		{Offset: 0x18, Length: 8, Type: "fill", FillValue: 0xff},
+		{Offset: 0x20, Length: 8, Type: "continue"},
+		{Offset: 0x28, Length: 4, Type: "load", GroupSize: 4, Reverse: true, Filename: "t.06"},
+		{Offset: 0x30, Length: 8, Type: "load", GroupSize: 1, Skip: 1, Filename: "t.07"},
+	}}
+	regionBinary, err := ProcessRegionFromZip(writeRomDir(t, files), region)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(regionBinary[0x18:0x20], bytes.Repeat([]byte{0xff}, 8)) {
+		t.Errorf("fill is % x, expected ff", regionBinary[0x18:0x20])
+	}
+	split, err := SplitRegion(region, regionBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(split) != len(files) {
+		t.Errorf("split %d files, expected %d", len(split), len(files))
+	}
+	for filename, contents := range files {
+		if !bytes.Equal(split[filename], contents) {
+			t.Errorf("%s is\n% x\nexpected\n% x", filename, split[filename], contents)
+		}
+	}
+	if _, err := SplitRegion(region, regionBinary[:0x38]); err == nil {
+		t.Error("splitting a short region didn't fail")
+	}
+}
+
+func TestSplitRegionReload(t *testing.T) {
+	contents := randomBytes(10, 8)
+	region := RomRegion{Size: 0x18, Operations: []RomRegionOperation{
+		{Offset: 0x00, Length: 4, Type: "load", GroupSize: 2, Reverse: true, Filename: "t.03"},
+		{Offset: 0x08, Length: 8, Type: "reload"},
+		{Offset: 0x10, Length: 8, Type: "reload"},
+	}}
+	regionBinary, err := ProcessRegionFromZip(writeRomDir(t, map[string][]byte{"t.03": contents}), region)
+	if err != nil {
+		t.Fatal(err)
+	}
+	split, err := SplitRegion(region, regionBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(split["t.03"], contents) {
+		t.Errorf("t.03 is\n% x\nexpected\n% x", split["t.03"], contents)
+	}
+	ignore := RomRegion{Size: 0x4, Operations: []RomRegionOperation{
+		{Offset: 0, Length: 2, Type: "load", Filename: "t.03"},
+		{Length: 2, Type: "ignore"},
+		{Offset: 2, Length: 2, Type: "continue"},
+	}}
+	if _, err := SplitRegion(ignore, regionBinary[:4]); err == nil {
+		t.Error("splitting a file with ignored bytes didn't fail")
+	}
+}
@@ -1,8 +1,16 @@
 package cps2rom
 
 import (
+	"cmp"
 	_ "embed"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/MBDesu/mbdcps2/Resources"
 )
 
 type Roms = map[string]RomDefinition
@@ -13,6 +21,8 @@ type RomRegion struct {
 }
 
 type RomDefinition struct {
+	// Parent is the ROM set a clone shares files with, as in MAME
+	Parent   string    `json:"parent,omitempty"`
 	Maincpu  RomRegion `json:"maincpu,omitempty"`
 	Gfx      RomRegion `json:"gfx,omitempty"`
 	Audiocpu RomRegion `json:"audiocpu,omitempty"`
@@ -29,18 +39,147 @@ type RomRegionOperation struct {
 	Reverse   bool   `json:"reverse,omitempty"`
 	Filename  string `json:"filename,omitempty"`
 	FillValue int    `json:"fillValue,omitempty"`
+	// Crc and Sha1 are the hashes of the whole file, in lowercase hex, on
+	// the operation that loads it
+	Crc  string `json:"crc,omitempty"`
+	Sha1 string `json:"sha1,omitempty"`
 }
 
+// roms.json holds the definitions of MAME's CPS2 driver, cps2.cpp. The copy
+// here came with the project, from before ParseMameDriver, and has no hashes;
+// regenerating it through the genroms flag adds them in the same shape.
+//
 //go:embed roms.json
 var romsBytes []byte
 
 var RomDefinitions *Roms
 
+// ParseRoms reads the built in definitions, then the overlays in
+// OverlayDirectory, if there are any. Without a configuration directory there
+// can't be any, so that's only warned about.
 func ParseRoms() error {
 	err := json.Unmarshal(romsBytes, &RomDefinitions)
+	if err != nil {
+		return err
+	}
+	overlayDir, err := OverlayDirectory()
+	if err != nil {
+		Resources.Logger.Warn(fmt.Sprintf("Not loading ROM definition overlays: %s", err))
+		return nil
+	}
+	if _, err := os.Stat(overlayDir); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	_, err = LoadRomOverlays(overlayDir)
 	return err
 }
 
+// MarshalJSON writes the fields of the operation's type, zero or not, as
+// roms.json has them, so regenerated definitions diff cleanly against it.
+// Hashes are only written when a load has them.
+func (operation RomRegionOperation) MarshalJSON() ([]byte, error) {
+	switch strings.ToLower(operation.Type) {
+	case "load":
+		return json.Marshal(struct {
+			Offset    int    `json:"offset"`
+			Length    int    `json:"length"`
+			Type      string `json:"type"`
+			GroupSize int    `json:"groupSize"`
+			Skip      int    `json:"skip"`
+			Reverse   bool   `json:"reverse"`
+			Filename  string `json:"filename"`
+			Crc       string `json:"crc,omitempty"`
+			Sha1      string `json:"sha1,omitempty"`
+		}{operation.Offset, operation.Length, operation.Type, operation.GroupSize, operation.Skip, operation.Reverse, operation.Filename, operation.Crc, operation.Sha1})
+	case "continue", "reload", "ignore":
+		return json.Marshal(struct {
+			Offset int    `json:"offset"`
+			Length int    `json:"length"`
+			Type   string `json:"type"`
+		}{operation.Offset, operation.Length, operation.Type})
+	case "fill":
+		return json.Marshal(struct {
+			Offset    int    `json:"offset"`
+			Length    int    `json:"length"`
+			Type      string `json:"type"`
+			FillValue int    `json:"fillValue"`
+		}{operation.Offset, operation.Length, operation.Type, operation.FillValue})
+	}
+	// without MarshalJSON, so it isn't called again
+	type plainOperation RomRegionOperation
+	return json.Marshal(plainOperation(operation))
+}
+
 func GetRomDefinition(romSetName string) RomDefinition {
 	return (*RomDefinitions)[romSetName]
 }
+
+// Regions returns the regions of romDef in the order their files are loaded.
+func (romDef RomDefinition) Regions() []RomRegion {
+	return []RomRegion{romDef.Maincpu, romDef.Audiocpu, romDef.Qsound, romDef.Gfx, romDef.Key}
+}
+
+// RegionNames names the regions Regions returns, in the same order.
+var RegionNames = []string{"maincpu", "audiocpu", "qsound", "gfx", "key"}
+
+// Region returns the region of romDef named regionName, one of RegionNames.
+func (romDef RomDefinition) Region(regionName string) (RomRegion, bool) {
+	switch regionName {
+	case "maincpu":
+		return romDef.Maincpu, true
+	case "audiocpu":
+		return romDef.Audiocpu, true
+	case "qsound":
+		return romDef.Qsound, true
+	case "gfx":
+		return romDef.Gfx, true
+	case "key":
+		return romDef.Key, true
+	}
+	return RomRegion{}, false
+}
+
+// RomFile is a file a RomDefinition loads. Its hashes are empty when the
+// definition doesn't have them.
+type RomFile struct {
+	Size int    `json:"size"`
+	Crc  string `json:"crc,omitempty"`
+	Sha1 string `json:"sha1,omitempty"`
+}
+
+// Files returns every file romDef loads, by name. A file's size includes the
+// data its continue and ignore operations go on to, but not what's reloaded.
+func (romDef RomDefinition) Files() map[string]RomFile {
+	files := map[string]RomFile{}
+	for _, region := range romDef.Regions() {
+		lastFilename := ""
+		filePtr := 0
+		for _, operation := range region.Operations {
+			switch strings.ToLower(operation.Type) {
+			case "load":
+				if operation.Filename == "" {
+					continue
+				}
+				file := files[operation.Filename]
+				file.Crc = cmp.Or(file.Crc, strings.ToLower(operation.Crc))
+				file.Sha1 = cmp.Or(file.Sha1, strings.ToLower(operation.Sha1))
+				files[operation.Filename] = file
+				lastFilename, filePtr = operation.Filename, operation.Length
+			case "continue", "ignore":
+				filePtr += operation.Length
+			case "reload":
+				filePtr = operation.Length
+			default:
+				continue
+			}
+			if lastFilename != "" {
+				file := files[lastFilename]
+				file.Size = max(file.Size, filePtr)
+				files[lastFilename] = file
+			}
+		}
+	}
+	return files
+}
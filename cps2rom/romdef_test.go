@@ -0,0 +1,132 @@
+package cps2rom
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRomRegionOperationMarshalJSON(t *testing.T) {
+	tests := []struct {
+		operation RomRegionOperation
+		want      string
+	}{
+		{
+			RomRegionOperation{Length: 0x80000, Type: "load", GroupSize: 2, Filename: "t.03"},
+			`{"offset":0,"length":524288,"type":"load","groupSize":2,"skip":0,"reverse":false,"filename":"t.03"}`,
+		},
+		{
+			RomRegionOperation{Offset: 2, Length: 0x80000, Type: "load", GroupSize: 2, Skip: 6, Filename: "t.13m", Crc: "deadbeef", Sha1: "0123"},
+			`{"offset":2,"length":524288,"type":"load","groupSize":2,"skip":6,"reverse":false,"filename":"t.13m","crc":"deadbeef","sha1":"0123"}`,
+		},
+		{
+			RomRegionOperation{Offset: 0x10000, Length: 0x18000, Type: "continue"},
+			`{"offset":65536,"length":98304,"type":"continue"}`,
+		},
+		{
+			RomRegionOperation{Offset: 0x80000, Length: 0x80000, Type: "reload"},
+			`{"offset":524288,"length":524288,"type":"reload"}`,
+		},
+		{
+			RomRegionOperation{Offset: 0x100, Length: 0x100, Type: "fill"},
+			`{"offset":256,"length":256,"type":"fill","fillValue":0}`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := json.Marshal(tt.operation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("marshalled %s, expected %s", got, tt.want)
+		}
+		var operation RomRegionOperation
+		if err := json.Unmarshal(got, &operation); err != nil {
+			t.Fatal(err)
+		}
+		if operation != tt.operation {
+			t.Errorf("unmarshalled %+v, expected %+v", operation, tt.operation)
+		}
+	}
+}
+
+// Re-marshalling the built in definitions must give back roms.json's regions
+// field for field.
+func TestRomsJsonShape(t *testing.T) {
+	var roms Roms
+	if err := json.Unmarshal(romsBytes, &roms); err != nil {
+		t.Fatal(err)
+	}
+	var original map[string]map[string]any
+	if err := json.Unmarshal(romsBytes, &original); err != nil {
+		t.Fatal(err)
+	}
+	for romSetName, romDef := range roms {
+		for i, region := range romDef.Regions() {
+			if len(region.Operations) == 0 {
+				continue
+			}
+			marshalled, err := json.Marshal(region)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got any
+			if err := json.Unmarshal(marshalled, &got); err != nil {
+				t.Fatal(err)
+			}
+			if want := original[romSetName][RegionNames[i]]; !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s's %s re-marshals as %s, expected %v", romSetName, RegionNames[i], marshalled, want)
+			}
+		}
+	}
+}
+
+func TestParseRomsOverlays(t *testing.T) {
+	overlay := `{"homebrew": {"maincpu": {"size": 16, "operations": [{"length": 16, "type": "load", "filename": "hb.03"}]}}}`
+	tests := []struct {
+		name     string
+		overlays map[string]string
+		isError  bool
+	}{
+		{name: "no overlay directory"},
+		{name: "overlay", overlays: map[string]string{"homebrew.json": overlay}},
+		{name: "invalid overlay", overlays: map[string]string{"homebrew.json": `{"homebrew": {}}`}, isError: true},
+		{name: "malformed overlay", overlays: map[string]string{"homebrew.json": `{"homebrew":`}, isError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useRomDefinitions(t, nil)
+			configDir := t.TempDir()
+			t.Setenv("XDG_CONFIG_HOME", configDir)
+			if tt.overlays != nil {
+				overlayDir := filepath.Join(configDir, "mbdcps2", "roms")
+				if err := os.MkdirAll(overlayDir, 0755); err != nil {
+					t.Fatal(err)
+				}
+				for filename, contents := range tt.overlays {
+					if err := os.WriteFile(filepath.Join(overlayDir, filename), []byte(contents), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			err := ParseRoms()
+			if tt.isError {
+				if err == nil {
+					t.Error("ParseRoms didn't fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := (*RomDefinitions)["sfa"]; !ok {
+				t.Error("the built in definitions weren't loaded")
+			}
+			if _, ok := (*RomDefinitions)["homebrew"]; ok != (tt.overlays != nil) {
+				t.Errorf("homebrew is defined: %t, expected %t", ok, tt.overlays != nil)
+			}
+		})
+	}
+}
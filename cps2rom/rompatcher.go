@@ -1,10 +1,8 @@
 package cps2rom
 
 import (
-	"archive/zip"
 	"encoding/xml"
 	"fmt"
-	"io"
 	"strconv"
 	"strings"
 
@@ -110,7 +108,7 @@ func mapOffsetToFile(baseOffset int64, offset int64, romRegion RomRegion) (strin
 	return "", -1
 }
 
-func PatchRomRegionWithMra(romZip *zip.ReadCloser, mra MraXml, romRegion RomRegion, fileContentMap map[string][]byte, baseOffset int, outputFilepath string) error {
+func PatchRomRegionWithMra(romZip *RomSource, mra MraXml, romRegion RomRegion, fileContentMap map[string][]byte, baseOffset int, outputFilepath string) error {
 	for _, rom := range mra.Rom {
 		lastOperationFilename := ""
 		for _, patch := range rom.Patch {
@@ -160,23 +158,15 @@ func createUint16ArrayFromUint8Array(arr []uint8) []uint16 {
 	return newArr
 }
 
-func DiffRomRegion(baseOffset int, region RomRegion, first *zip.ReadCloser, second *zip.ReadCloser) (*[]RomPatch, error) {
+func DiffRomRegion(baseOffset int, region RomRegion, first *RomSource, second *RomSource) (*[]RomPatch, error) {
 	var romPatches []RomPatch
 	for _, operation := range region.Operations {
 		if operation.Filename != "" {
-			l, err := first.Open(operation.Filename)
+			lb, err := first.ReadFile(operation.Filename)
 			if err != nil {
 				return nil, err
 			}
-			r, err := second.Open(operation.Filename)
-			if err != nil {
-				return nil, err
-			}
-			lb, err := io.ReadAll(l)
-			if err != nil {
-				return nil, err
-			}
-			rb, err := io.ReadAll(r)
+			rb, err := second.ReadFile(operation.Filename)
 			if err != nil {
 				return nil, err
 			}
@@ -185,7 +175,7 @@ func DiffRomRegion(baseOffset int, region RomRegion, first *zip.ReadCloser, seco
 			bytesChanged := 0
 			for i := 0; i < operation.Length/2; i++ {
 				data := make([]uint16, 0, 0x1000)
-				for ; l16[i] != r16[i]; i++ {
+				for ; i < operation.Length/2 && l16[i] != r16[i]; i++ {
 					data = append(data, r16[i])
 				}
 				if len(data) > 0 {
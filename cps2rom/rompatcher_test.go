@@ -0,0 +1,49 @@
+package cps2rom
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffRomRegion(t *testing.T) {
+	region := RomRegion{Size: 0x10, Operations: []RomRegionOperation{
+		{Offset: 0, Length: 8, Type: "load", Filename: "t.01"},
+		{Offset: 8, Length: 8, Type: "load", Filename: "t.02"},
+	}}
+	first := writeRomDir(t, map[string][]byte{
+		"t.01": sequence(0x00, 8),
+		"t.02": sequence(0x10, 8),
+	})
+	tests := []struct {
+		name   string
+		second map[string][]byte
+		want   []RomPatch
+	}{
+		{
+			name:   "unchanged",
+			second: map[string][]byte{"t.01": sequence(0x00, 8), "t.02": sequence(0x10, 8)},
+		},
+		{
+			name:   "changed in the middle",
+			second: map[string][]byte{"t.01": {0x00, 0x01, 0xaa, 0xbb, 0xcc, 0x05, 0x06, 0x07}, "t.02": sequence(0x10, 8)},
+			want:   []RomPatch{{"t.01", 0x102, []uint8{0xaa, 0xbb, 0xcc, 0x05}}},
+		},
+		{
+			// used to index past the end of the file
+			name:   "changed up to the last word",
+			second: map[string][]byte{"t.01": sequence(0x00, 8), "t.02": {0xaa, 0x11, 0x12, 0x13, 0x14, 0x15, 0xbb, 0xcc}},
+			want:   []RomPatch{{"t.02", 0x108, []uint8{0xaa, 0x11}}, {"t.02", 0x10e, []uint8{0xbb, 0xcc}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patches, err := DiffRomRegion(0x100, region, first, writeRomDir(t, tt.second))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*patches, tt.want) {
+				t.Errorf("patches are %x, expected %x", *patches, tt.want)
+			}
+		})
+	}
+}
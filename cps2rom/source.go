@@ -0,0 +1,176 @@
+package cps2rom
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	file_utils "github.com/MBDesu/mbdcps2/utils"
+)
+
+// A ROM set's files are read from a .zip or, as MAME's rompath allows, from a
+// directory of loose files, which is handier for keeping a set under version
+// control. Either way they're read through a RomSource.
+
+// RomSource is the files of a ROM .zip or directory. Like a zip.Reader's, its
+// File can have files of other sources added, as AddParentFiles does.
+type RomSource struct {
+	Path    string
+	File    []*SourceFile
+	closers []io.Closer
+}
+
+// SourceFile is a file of a RomSource.
+type SourceFile struct {
+	Name string
+	Size int
+	fsys fs.FS
+	info fs.FileInfo
+	// added by AddParentFiles, so it's left out of what's written back
+	fromParent bool
+}
+
+// Open opens the file for reading.
+func (f *SourceFile) Open() (fs.File, error) {
+	return f.fsys.Open(f.Name)
+}
+
+// ReadAll reads the whole file.
+func (f *SourceFile) ReadAll() ([]byte, error) {
+	return fs.ReadFile(f.fsys, f.Name)
+}
+
+// CRC32 returns the file's CRC32, from its header if it's in a .zip.
+func (f *SourceFile) CRC32() (uint32, error) {
+	if header, ok := f.info.Sys().(*zip.FileHeader); ok {
+		return header.CRC32, nil
+	}
+	contents, err := f.ReadAll()
+	if err != nil {
+		return 0, err
+	}
+	return crc32.ChecksumIEEE(contents), nil
+}
+
+// OpenRomSource opens the ROM .zip or directory at romSetPath. Only the files at the
+// top of a directory are read, while a .zip's may be in folders.
+func OpenRomSource(romSetPath string) (*RomSource, error) {
+	s := &RomSource{Path: filepath.Clean(romSetPath)}
+	info, err := os.Stat(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		romZip, err := file_utils.GetZipFileReader(s.Path)
+		if err != nil {
+			return nil, err
+		}
+		s.closers = append(s.closers, romZip)
+		for _, file := range romZip.File {
+			if !file.FileInfo().IsDir() {
+				s.File = append(s.File, &SourceFile{Name: file.Name, Size: int(file.UncompressedSize64), fsys: &romZip.Reader, info: file.FileInfo()})
+			}
+		}
+		return s, nil
+	}
+	fsys := os.DirFS(s.Path)
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		// Stat follows links, which Info doesn't
+		fileInfo, err := fs.Stat(fsys, entry.Name())
+		if err != nil || !fileInfo.Mode().IsRegular() {
+			continue
+		}
+		s.File = append(s.File, &SourceFile{Name: entry.Name(), Size: int(fileInfo.Size()), fsys: fsys, info: fileInfo})
+	}
+	return s, nil
+}
+
+// Close closes the source, and those it has files from.
+func (s *RomSource) Close() error {
+	var errs []error
+	for _, closer := range s.closers {
+		errs = append(errs, closer.Close())
+	}
+	s.closers = nil
+	return errors.Join(errs...)
+}
+
+// Find returns the file named filename, or in a folder of a .zip, or nil.
+func (s *RomSource) Find(filename string) *SourceFile {
+	var inFolder *SourceFile
+	for _, file := range s.File {
+		if file.Name == filename {
+			return file
+		}
+		if inFolder == nil && path.Base(file.Name) == filename {
+			inFolder = file
+		}
+	}
+	return inFolder
+}
+
+// ReadFile reads the file named filename.
+func (s *RomSource) ReadFile(filename string) ([]byte, error) {
+	file := s.Find(filename)
+	if file == nil {
+		return nil, fmt.Errorf("%s not found in %s: %w", filename, s.Path, fs.ErrNotExist)
+	}
+	return file.ReadAll()
+}
+
+// ReadFiles reads every file, by name.
+func (s *RomSource) ReadFiles() (map[string][]byte, error) {
+	contents := make(map[string][]byte, len(s.File))
+	for _, file := range s.File {
+		fileContents, err := file.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		contents[file.Name] = fileContents
+	}
+	return contents, nil
+}
+
+// FindRomSetPath returns the path of ROM set romSetName's .zip or directory in
+// directory, or "" if it has neither.
+func FindRomSetPath(directory string, romSetName string) string {
+	zipFilepath := filepath.Join(directory, romSetName+".zip")
+	if _, err := os.Stat(zipFilepath); err == nil {
+		return zipFilepath
+	}
+	romSetDir := filepath.Join(directory, romSetName)
+	if info, err := os.Stat(romSetDir); err == nil && info.IsDir() {
+		return romSetDir
+	}
+	return ""
+}
+
+// copySourceFileToNewZip copies file into newZip under the same name.
+func copySourceFileToNewZip(file *SourceFile, newZip *zip.Writer) error {
+	romFile, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer romFile.Close()
+	header, err := zip.FileInfoHeader(file.info)
+	if err != nil {
+		return err
+	}
+	header.Name = file.Name
+	newZippedFile, err := newZip.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(newZippedFile, romFile)
+	return err
+}
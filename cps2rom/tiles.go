@@ -0,0 +1,248 @@
+package cps2rom
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Tiles are read from the unshuffled gfx region in MAME's CPS1 layouts. Each
+// row of 8 pixels is 4 bytes, one per bitplane, the first the least
+// significant, with the leftmost pixel in each byte's top bit:
+//
+// | Size  | Bytes | Row stride | Rows of 8 pixels at          |
+// | ----- | ----- | ---------- | ---------------------------- |
+// | 8x8   | 64    | 8          | +0, or +4 for an odd tile    |
+// | 16x16 | 128   | 8          | +0, +4                       |
+// | 32x32 | 512   | 16         | +0, +4, +8, +12              |
+//
+// An 8x8 tile's row only fills half its stride, so each 64 bytes holds two,
+// the left halves the even tile and the right the odd, as MAME's two 8x8
+// layouts read them.
+
+const ColorsPerTile = 16
+
+// TileSheetColumns is how many tiles wide a tile sheet is.
+const TileSheetColumns = 16
+
+var TileSizes = []int{8, 16, 32}
+
+// tileOffset returns where tile starts in the gfx region, and the stride of
+// its rows.
+func tileOffset(tileSize int, tile int) (int, int) {
+	if tileSize == 8 {
+		return tile/2*64 + tile%2*4, 8
+	}
+	return tile * tileSize * tileSize / 2, tileSize / 2
+}
+
+// TileCount returns how many tiles of tileSize an unshuffled gfx region of
+// gfxSize bytes holds.
+func TileCount(gfxSize int, tileSize int) int {
+	return gfxSize * 2 / (tileSize * tileSize)
+}
+
+func checkTile(gfx []byte, tileSize int, tile int) error {
+	if !slices.Contains(TileSizes, tileSize) {
+		return fmt.Errorf("tiles must be 8, 16 or 32 pixels, not %d", tileSize)
+	}
+	if tile < 0 || tile >= TileCount(len(gfx), tileSize) {
+		return fmt.Errorf("tile 0x%x is out of range, the gfx has 0x%x %dx%d tiles", tile, TileCount(len(gfx), tileSize), tileSize, tileSize)
+	}
+	return nil
+}
+
+// DecodeTile returns the pens, 0-15, of tile's pixels, row by row.
+func DecodeTile(gfx []byte, tileSize int, tile int) ([]uint8, error) {
+	if err := checkTile(gfx, tileSize, tile); err != nil {
+		return nil, err
+	}
+	pixels := make([]uint8, tileSize*tileSize)
+	offset, stride := tileOffset(tileSize, tile)
+	for y := range tileSize {
+		for x := range tileSize {
+			planes := gfx[offset+y*stride+x/8*4:]
+			bit := uint(7 - x%8)
+			pixels[y*tileSize+x] = (planes[3]>>bit&1)<<3 | (planes[2]>>bit&1)<<2 | (planes[1]>>bit&1)<<1 | planes[0]>>bit&1
+		}
+	}
+	return pixels, nil
+}
+
+// EncodeTile writes the pens of tile's pixels, row by row, into gfx. It undoes
+// DecodeTile.
+func EncodeTile(gfx []byte, tileSize int, tile int, pixels []uint8) error {
+	if err := checkTile(gfx, tileSize, tile); err != nil {
+		return err
+	}
+	if len(pixels) != tileSize*tileSize {
+		return fmt.Errorf("a %dx%d tile has %d pixels, not %d", tileSize, tileSize, tileSize*tileSize, len(pixels))
+	}
+	offset, stride := tileOffset(tileSize, tile)
+	for y := range tileSize {
+		for x := range tileSize {
+			planes := gfx[offset+y*stride+x/8*4:]
+			bit := uint(7 - x%8)
+			pen := pixels[y*tileSize+x]
+			for plane := range 4 {
+				planes[plane] = planes[plane]&^(1<<bit) | (pen>>plane&1)<<bit
+			}
+		}
+	}
+	return nil
+}
+
+// TileSheet draws tiles first up to but not including end of the unshuffled
+// gfx region, TileSheetColumns to a row, with palette's colors.
+func TileSheet(gfx []byte, tileSize int, first int, end int, palette color.Palette) (*image.Paletted, error) {
+	if first >= end {
+		return nil, fmt.Errorf("no tiles from 0x%x to 0x%x", first, end)
+	}
+	for _, tile := range []int{first, end - 1} {
+		if err := checkTile(gfx, tileSize, tile); err != nil {
+			return nil, err
+		}
+	}
+	count := end - first
+	columns := min(count, TileSheetColumns)
+	rows := (count + columns - 1) / columns
+	sheet := image.NewPaletted(image.Rect(0, 0, columns*tileSize, rows*tileSize), palette)
+	for i := range count {
+		pixels, err := DecodeTile(gfx, tileSize, first+i)
+		if err != nil {
+			return nil, err
+		}
+		left, top := i%columns*tileSize, i/columns*tileSize
+		for y := range tileSize {
+			copy(sheet.Pix[sheet.PixOffset(left, top+y):], pixels[y*tileSize:(y+1)*tileSize])
+		}
+	}
+	return sheet, nil
+}
+
+// GrayscalePalette returns 16 grays, pen 0 black and pen 15 white.
+func GrayscalePalette() color.Palette {
+	palette := make(color.Palette, ColorsPerTile)
+	for pen := range palette {
+		palette[pen] = color.Gray{uint8(pen * 0x11)}
+	}
+	return palette
+}
+
+// ReadPalette reads a tile's 16 colors from a JASC-PAL file, as Paint Shop Pro
+// and most tile editors write, or from big endian CPS2 palette words, as in
+// palette RAM, taking the first 16.
+func ReadPalette(contents []byte) (color.Palette, error) {
+	if bytes.HasPrefix(contents, []byte("JASC-PAL")) {
+		return readJascPalette(contents)
+	}
+	if len(contents) < ColorsPerTile*2 {
+		return nil, fmt.Errorf("palette is %d bytes, expected at least %d", len(contents), ColorsPerTile*2)
+	}
+	palette := make(color.Palette, ColorsPerTile)
+	for pen := range palette {
+		palette[pen] = cps2Color(binary.BigEndian.Uint16(contents[pen*2:]))
+	}
+	return palette, nil
+}
+
+// cps2Color converts a palette word, 4 bits each of brightness, red, green and
+// blue, as MAME does.
+func cps2Color(word uint16) color.RGBA {
+	bright := 0x0f + int(word>>12)<<1
+	channel := func(shift uint) uint8 {
+		return uint8(int(word>>shift&0x0f) * 0x11 * bright / 0x2d)
+	}
+	return color.RGBA{channel(8), channel(4), channel(0), 0xff}
+}
+
+func readJascPalette(contents []byte) (color.Palette, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	var lines []string
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	// JASC-PAL, the version, the count and then a color a line
+	if len(lines) < 3+ColorsPerTile {
+		return nil, fmt.Errorf("JASC-PAL palette has %d colors, expected at least %d", max(len(lines)-3, 0), ColorsPerTile)
+	}
+	palette := make(color.Palette, ColorsPerTile)
+	for pen := range palette {
+		fields := strings.Fields(lines[3+pen])
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("JASC-PAL color %d isn't <red> <green> <blue>", pen)
+		}
+		var rgb [3]uint8
+		for i := range rgb {
+			value, err := strconv.ParseUint(fields[i], 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("JASC-PAL color %d: %w", pen, err)
+			}
+			rgb[i] = uint8(value)
+		}
+		palette[pen] = color.RGBA{rgb[0], rgb[1], rgb[2], 0xff}
+	}
+	return palette, nil
+}
+
+// ImportTileSheet encodes the tiles of sheet, laid out as TileSheet draws
+// them, into the unshuffled gfx region from tile first. With a count above 0,
+// only that many tiles are, so the blank end of a sheet's last row is left
+// out. A paletted sheet's pixels are pens already and must be below 16;
+// others are quantized to the nearest of palette's colors. It returns how many
+// tiles it encoded and how many pixels weren't exactly one of the colors.
+func ImportTileSheet(gfx []byte, tileSize int, first int, count int, sheet image.Image, palette color.Palette) (int, int, error) {
+	bounds := sheet.Bounds()
+	if bounds.Dx()%tileSize != 0 || bounds.Dy()%tileSize != 0 {
+		return 0, 0, fmt.Errorf("sheet is %dx%d, not a whole number of %dx%d tiles", bounds.Dx(), bounds.Dy(), tileSize, tileSize)
+	}
+	columns, rows := bounds.Dx()/tileSize, bounds.Dy()/tileSize
+	if rows > 1 && columns != TileSheetColumns {
+		return 0, 0, fmt.Errorf("sheet is %d tiles wide, expected %d", columns, TileSheetColumns)
+	}
+	if count <= 0 || count > columns*rows {
+		count = columns * rows
+	}
+	paletted, isPaletted := sheet.(*image.Paletted)
+	quantized := 0
+	pixels := make([]uint8, tileSize*tileSize)
+	for i := range count {
+		left, top := bounds.Min.X+i%columns*tileSize, bounds.Min.Y+i/columns*tileSize
+		for y := range tileSize {
+			for x := range tileSize {
+				var pen int
+				if isPaletted {
+					pen = int(paletted.ColorIndexAt(left+x, top+y))
+					if pen >= ColorsPerTile {
+						return 0, 0, fmt.Errorf("pixel %d,%d is pen %d, tiles have %d", left+x, top+y, pen, ColorsPerTile)
+					}
+				} else {
+					c := sheet.At(left+x, top+y)
+					pen = palette.Index(c)
+					if !isSameColor(c, palette[pen]) {
+						quantized++
+					}
+				}
+				pixels[y*tileSize+x] = uint8(pen)
+			}
+		}
+		if err := EncodeTile(gfx, tileSize, first+i, pixels); err != nil {
+			return 0, 0, err
+		}
+	}
+	return count, quantized, nil
+}
+
+func isSameColor(a color.Color, b color.Color) bool {
+	ar, ag, ab, aa := a.RGBA()
+	br, bg, bb, ba := b.RGBA()
+	return ar == br && ag == bg && ab == bb && aa == ba
+}
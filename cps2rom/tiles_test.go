@@ -0,0 +1,235 @@
+package cps2rom
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTileRoundTrip(t *testing.T) {
+	gfx := randomBytes(2, 0x2000)
+	for _, tileSize := range TileSizes {
+		t.Run(fmt.Sprintf("%dx%d", tileSize, tileSize), func(t *testing.T) {
+			// every tile, encoded into blank gfx, gives back the whole gfx
+			encoded := make([]byte, len(gfx))
+			for tile := range TileCount(len(gfx), tileSize) {
+				pixels, err := DecodeTile(gfx, tileSize, tile)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := EncodeTile(encoded, tileSize, tile, pixels); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !bytes.Equal(encoded, gfx) {
+				t.Error("encoding the decoded tiles didn't give back the gfx")
+			}
+			pixels := randomBytes(3, tileSize*tileSize)
+			for i := range pixels {
+				pixels[i] &= 0x0f
+			}
+			if err := EncodeTile(encoded, tileSize, 3, pixels); err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := DecodeTile(encoded, tileSize, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decoded, pixels) {
+				t.Error("decoding an encoded tile didn't give back its pixels")
+			}
+		})
+	}
+}
+
+// The tiles are laid out as MAME's cps1_layout8x8, 16x16 and 32x32 read them,
+// with the planes at bits 24, 16, 8 and 0 of each row, most significant first.
+func TestDecodeTileLayout(t *testing.T) {
+	tests := []struct {
+		name     string
+		tileSize int
+		tile     int
+		bytes    map[int]uint8
+		pens     map[[2]int]uint8
+	}{
+		{
+			name:     "16x16",
+			tileSize: 16,
+			tile:     1,
+			bytes:    map[int]uint8{128 + 3: 0x80, 128: 0x01, 128 + 5: 0x40, 128 + 8 + 2: 0x81, 128 + 15*8 + 7: 0x01},
+			pens:     map[[2]int]uint8{{0, 0}: 8, {7, 0}: 1, {9, 0}: 2, {0, 1}: 4, {7, 1}: 4, {15, 15}: 8},
+		},
+		{
+			name:     "8x8, even",
+			tileSize: 8,
+			tile:     2,
+			bytes:    map[int]uint8{64 + 1: 0x80, 64 + 7*8: 0x01},
+			pens:     map[[2]int]uint8{{0, 0}: 2, {7, 7}: 1},
+		},
+		{
+			name:     "8x8, odd",
+			tileSize: 8,
+			tile:     3,
+			bytes:    map[int]uint8{64 + 4 + 3: 0x01, 64 + 4 + 8: 0x80},
+			pens:     map[[2]int]uint8{{7, 0}: 8, {0, 1}: 1},
+		},
+		{
+			name:     "32x32",
+			tileSize: 32,
+			tile:     0,
+			bytes:    map[int]uint8{12: 0x80, 2*16 + 4 + 3: 0x01, 31*16 + 12 + 2: 0x01},
+			pens:     map[[2]int]uint8{{24, 0}: 1, {15, 2}: 8, {31, 31}: 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gfx := make([]byte, 0x400)
+			for offset, b := range tt.bytes {
+				gfx[offset] = b
+			}
+			pixels, err := DecodeTile(gfx, tt.tileSize, tt.tile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := make([]uint8, tt.tileSize*tt.tileSize)
+			for xy, pen := range tt.pens {
+				want[xy[1]*tt.tileSize+xy[0]] = pen
+			}
+			for i := range want {
+				if pixels[i] != want[i] {
+					t.Errorf("pixel %d,%d is pen %d, expected %d", i%tt.tileSize, i/tt.tileSize, pixels[i], want[i])
+				}
+			}
+			encoded := make([]byte, len(gfx))
+			if err := EncodeTile(encoded, tt.tileSize, tt.tile, want); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(encoded, gfx) {
+				t.Error("encoding the tile didn't give back its bytes")
+			}
+		})
+	}
+}
+
+func TestTileErrors(t *testing.T) {
+	gfx := make([]byte, 0x400)
+	for _, tt := range []struct{ tileSize, tile int }{{12, 0}, {16, -1}, {16, 8}, {8, 32}, {32, 2}} {
+		if _, err := DecodeTile(gfx, tt.tileSize, tt.tile); err == nil {
+			t.Errorf("decoding %dx%d tile %d didn't fail", tt.tileSize, tt.tileSize, tt.tile)
+		}
+	}
+	if err := EncodeTile(gfx, 16, 0, make([]uint8, 64)); err == nil {
+		t.Error("encoding 64 pixels into a 16x16 tile didn't fail")
+	}
+}
+
+func TestImportTileSheet(t *testing.T) {
+	gfx := randomBytes(4, 0x4000)
+	sheet, err := TileSheet(gfx, 16, 4, 40, GrayscalePalette())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rgba := image.NewRGBA(sheet.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), sheet, image.Point{}, draw.Src)
+	for _, tt := range []struct {
+		name  string
+		sheet image.Image
+	}{
+		{"paletted", sheet},
+		{"RGBA", rgba},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			imported := make([]byte, len(gfx))
+			copy(imported, gfx)
+			// blank the tiles so they have to be imported
+			for tile := 4; tile < 40; tile++ {
+				EncodeTile(imported, 16, tile, make([]uint8, 256))
+			}
+			count, quantized, err := ImportTileSheet(imported, 16, 4, 36, tt.sheet, GrayscalePalette())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != 36 || quantized != 0 {
+				t.Errorf("imported %d tiles, %d pixels quantized, expected 36 and 0", count, quantized)
+			}
+			if !bytes.Equal(imported, gfx) {
+				t.Error("importing the sheet didn't give back the gfx")
+			}
+		})
+	}
+	narrow := image.NewPaletted(image.Rect(0, 0, 8*16, 2*16), GrayscalePalette())
+	if _, _, err := ImportTileSheet(gfx, 16, 0, 0, narrow, GrayscalePalette()); err == nil {
+		t.Error("importing a sheet 8 tiles wide didn't fail")
+	}
+}
+
+func TestReadPalette(t *testing.T) {
+	var jasc bytes.Buffer
+	jasc.WriteString("JASC-PAL\r\n0100\r\n16\r\n")
+	for pen := range ColorsPerTile {
+		fmt.Fprintf(&jasc, "%d 0 255\r\n", pen)
+	}
+	words := make([]byte, 0x40)
+	copy(words, []byte{0xff, 0xff, 0x0f, 0x00, 0xf0, 0x80})
+	tests := []struct {
+		name     string
+		contents []byte
+		want     map[int]color.RGBA
+	}{
+		{"JASC-PAL", jasc.Bytes(), map[int]color.RGBA{0: {0, 0, 255, 0xff}, 15: {15, 0, 255, 0xff}}},
+		// full brightness white, dimmest red, full brightness half green
+		{"CPS2 words", words, map[int]color.RGBA{0: {0xff, 0xff, 0xff, 0xff}, 1: {0x55, 0, 0, 0xff}, 2: {0, 0x88, 0, 0xff}, 3: {0, 0, 0, 0xff}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			palette, err := ReadPalette(tt.contents)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(palette) != ColorsPerTile {
+				t.Fatalf("palette has %d colors, expected %d", len(palette), ColorsPerTile)
+			}
+			for pen, want := range tt.want {
+				if !isSameColor(palette[pen], want) {
+					t.Errorf("pen %d is %v, expected %v", pen, palette[pen], want)
+				}
+			}
+		})
+	}
+	for _, contents := range []string{"JASC-PAL\n0100\n16\n0 0 0\n", "JASC-PAL\n0100\n16\n" + strings.Repeat("0 0 256\n", ColorsPerTile), "short"} {
+		if _, err := ReadPalette([]byte(contents)); err == nil {
+			t.Errorf("%q didn't fail", contents)
+		}
+	}
+	if !slices.Equal(GrayscalePalette()[:2], color.Palette{color.Gray{0}, color.Gray{0x11}}) {
+		t.Error("the grayscale palette doesn't start black")
+	}
+}
+
+func TestTileSheet(t *testing.T) {
+	gfx := randomBytes(4, 0x4000)
+	sheet, err := TileSheet(gfx, 16, 4, 40, GrayscalePalette())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := sheet.Bounds().Size(); size != image.Pt(16*16, 3*16) {
+		t.Fatalf("sheet is %v, expected 256x48", size)
+	}
+	for _, tile := range []int{4, 20, 39} {
+		pixels, _ := DecodeTile(gfx, 16, tile)
+		left, top := (tile-4)%TileSheetColumns*16, (tile-4)/TileSheetColumns*16
+		for y := range 16 {
+			if row := sheet.Pix[sheet.PixOffset(left, top+y):][:16]; !bytes.Equal(row, pixels[y*16:(y+1)*16]) {
+				t.Fatalf("tile %d's row %d is % x, expected % x", tile, y, row, pixels[y*16:(y+1)*16])
+			}
+		}
+	}
+	if _, err := TileSheet(gfx, 16, 4, 4, GrayscalePalette()); err == nil {
+		t.Error("drawing no tiles didn't fail")
+	}
+}
@@ -0,0 +1,127 @@
+package m68k
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Access is how a program reads a word. Higher values win when a word is
+// read in more than one way.
+type Access uint8
+
+const (
+	// Unknown words weren't reached by the trace
+	Unknown Access = iota
+	// Data words are read as data
+	Data
+	// PcRelative words are read as data, but PC relative, which the CPU
+	// fetches like opcodes
+	PcRelative
+	// Opcode words are fetched as instructions or their extension words
+	Opcode
+)
+
+var accessNames = []string{"unknown", "data", "pcrel", "code"}
+
+func (a Access) String() string {
+	if int(a) < len(accessNames) {
+		return accessNames[a]
+	}
+	return fmt.Sprintf("access(%d)", a)
+}
+
+// IsOpcodeView reports whether the CPU sees the word as it fetches opcodes,
+// i.e. decrypted, rather than as it's stored.
+func (a Access) IsOpcodeView() bool {
+	return a == Opcode || a == PcRelative
+}
+
+// CodeMap holds the Access of every word of a program.
+type CodeMap []Access
+
+// Range is a run of words with the same Access, from byte address Start up to
+// but not including End.
+type Range struct {
+	Start  int
+	End    int
+	Access Access
+}
+
+func (m CodeMap) Ranges() []Range {
+	var ranges []Range
+	for i, access := range m {
+		if len(ranges) > 0 && ranges[len(ranges)-1].Access == access {
+			ranges[len(ranges)-1].End = 2*i + 2
+			continue
+		}
+		ranges = append(ranges, Range{2 * i, 2*i + 2, access})
+	}
+	return ranges
+}
+
+// Counts returns how many words there are of each Access.
+func (m CodeMap) Counts() map[Access]int {
+	counts := map[Access]int{}
+	for _, access := range m {
+		counts[access]++
+	}
+	return counts
+}
+
+// Encode writes the map as text, one range per line, e.g.
+//
+//	0x000000-0x000100 data
+//	0x000100-0x000400 code
+func (m CodeMap) Encode() []byte {
+	var b bytes.Buffer
+	b.WriteString("# start-end (exclusive) access: code, pcrel, data or unknown\n")
+	for _, r := range m.Ranges() {
+		fmt.Fprintf(&b, "0x%06x-0x%06x %s\n", r.Start, r.End, r.Access)
+	}
+	return b.Bytes()
+}
+
+// ParseCodeMap reads a map written by Encode for a program of size bytes.
+// Words it doesn't cover are Unknown, and blank lines and lines starting with #
+// are ignored, so maps may be written or edited by hand.
+func ParseCodeMap(text []byte, size int) (CodeMap, error) {
+	codeMap := make(CodeMap, size/2)
+	scanner := bufio.NewScanner(bytes.NewReader(text))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		startString, endString, isRange := strings.Cut(fields[0], "-")
+		if len(fields) != 2 || !isRange {
+			return nil, fmt.Errorf("code map line %d: expected <start>-<end> <access>", line)
+		}
+		start, err := strconv.ParseInt(startString, 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("code map line %d: %w", line, err)
+		}
+		end, err := strconv.ParseInt(endString, 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("code map line %d: %w", line, err)
+		}
+		if start%2 != 0 || end%2 != 0 || start >= end || int(end) > size {
+			return nil, fmt.Errorf("code map line %d: invalid range 0x%06x-0x%06x", line, start, end)
+		}
+		access := -1
+		for a, name := range accessNames {
+			if fields[1] == name {
+				access = a
+			}
+		}
+		if access < 0 {
+			return nil, fmt.Errorf("code map line %d: unknown access %s", line, fields[1])
+		}
+		for a := start; a < end; a += 2 {
+			codeMap[a/2] = Access(access)
+		}
+	}
+	return codeMap, scanner.Err()
+}
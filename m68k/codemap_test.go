@@ -0,0 +1,46 @@
+package m68k
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseCodeMapRoundTrip(t *testing.T) {
+	image := assemble(0x160, testProgram)
+	codeMap := Trace(image, image)
+	parsed, err := ParseCodeMap(codeMap.Encode(), len(image))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(parsed, codeMap) {
+		t.Errorf("parsed map is\n%s\nexpected\n%s", parsed.Encode(), codeMap.Encode())
+	}
+}
+
+func TestParseCodeMap(t *testing.T) {
+	codeMap, err := ParseCodeMap([]byte("# a hand written map\n\n0x100-0x104 code\n0x4-0x8 data\n  0x8-0xa   pcrel\n"), 0x10c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := CodeMap{0: Unknown, 1: Unknown, 2: Data, 3: Data, 4: PcRelative, 0x80: Opcode, 0x81: Opcode, 0x85: Unknown}
+	if !slices.Equal(codeMap, want) {
+		t.Errorf("parsed map is\n%s\nexpected\n%s", codeMap.Encode(), want.Encode())
+	}
+}
+
+func TestParseCodeMapErrors(t *testing.T) {
+	for _, text := range []string{
+		"0x100 code",
+		"0x100-0x104",
+		"0x100-0x104 code extra",
+		"0x101-0x104 code",
+		"0x104-0x100 code",
+		"0x100-0x202 code",
+		"0x100-0x104 opcode",
+		"start-0x104 code",
+	} {
+		if _, err := ParseCodeMap([]byte(text), 0x200); err == nil {
+			t.Errorf("%q didn't fail", text)
+		}
+	}
+}
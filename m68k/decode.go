@@ -0,0 +1,345 @@
+package m68k
+
+// The decoder only knows as much about 68000 instructions as tracing needs:
+// how many words each takes, where it can go next, and which memory it reads
+// or writes when that can be told without knowing the registers.
+
+type flow uint8
+
+const (
+	// flowNext continues with the next instruction
+	flowNext flow = iota
+	// flowBranch may go to target, or continue with the next instruction
+	flowBranch
+	// flowCall calls target, if known, then continues with the next instruction
+	flowCall
+	// flowJump goes to target, if known, and doesn't continue
+	flowJump
+	// flowReturn returns from a subroutine or exception
+	flowReturn
+	// flowIllegal isn't a 68000 instruction, so whatever led here wasn't code
+	flowIllegal
+)
+
+type reference struct {
+	address    int
+	size       int
+	pcRelative bool
+}
+
+type instruction struct {
+	// length in words, including extension words
+	length int
+	flow   flow
+	// target is -1 when it depends on registers
+	target     int
+	references []reference
+}
+
+type operand struct {
+	words      int
+	address    int
+	pcRelative bool
+	valid      bool
+}
+
+const addressMask = 0xffffff
+
+type decoder struct {
+	image []uint8
+}
+
+func (d *decoder) word(address int) (uint16, bool) {
+	if address < 0 || address+1 >= len(d.image) || address%2 != 0 {
+		return 0, false
+	}
+	return uint16(d.image[address])<<8 | uint16(d.image[address+1]), true
+}
+
+func (d *decoder) long(address int) (uint32, bool) {
+	high, ok := d.word(address)
+	if !ok {
+		return 0, false
+	}
+	low, ok := d.word(address + 2)
+	return uint32(high)<<16 | uint32(low), ok
+}
+
+// operand decodes the effective address mode/reg of size bytes whose extension
+// words start at ext. Its address is -1 if it's a register, immediate or
+// depends on one.
+func (d *decoder) operand(mode int, reg int, size int, ext int) operand {
+	o := operand{address: -1, valid: true}
+	switch mode {
+	case 0, 1, 2, 3, 4:
+	case 5, 6:
+		o.words = 1
+	case 7:
+		switch reg {
+		case 0:
+			o.words = 1
+			if w, ok := d.word(ext); ok {
+				o.address = int(int16(w)) & addressMask
+			}
+		case 1:
+			o.words = 2
+			if l, ok := d.long(ext); ok {
+				o.address = int(l) & addressMask
+			}
+		case 2:
+			o.words = 1
+			o.pcRelative = true
+			if w, ok := d.word(ext); ok {
+				o.address = (ext + int(int16(w))) & addressMask
+			}
+		case 3:
+			o.words = 1
+		case 4:
+			o.words = 1
+			if size == 4 {
+				o.words = 2
+			}
+		default:
+			o.valid = false
+		}
+	}
+	return o
+}
+
+// ea decodes the effective address in the low 6 bits of the opcode.
+func (d *decoder) ea(op uint16, size int, ext int) operand {
+	return d.operand(int(op>>3)&7, int(op)&7, size, ext)
+}
+
+func sizeOf(bits uint16) int {
+	return []int{1, 2, 4, 0}[bits&3]
+}
+
+// decode decodes the instruction at pc. Instructions that run off the end of
+// the image or use an invalid addressing mode are illegal.
+func (d *decoder) decode(pc int) instruction {
+	illegal := instruction{length: 1, flow: flowIllegal, target: -1}
+	op, ok := d.word(pc)
+	if !ok {
+		return illegal
+	}
+	in := instruction{length: 1, flow: flowNext, target: -1}
+	// adds an operand's extension words and, if it reads or writes known
+	// memory, a reference to it
+	add := func(o operand, size int, isAccess bool) bool {
+		if !o.valid {
+			return false
+		}
+		in.length += o.words
+		if isAccess && o.address >= 0 && size > 0 {
+			in.references = append(in.references, reference{o.address, size, o.pcRelative})
+		}
+		return true
+	}
+	next := func() int {
+		return pc + 2*in.length
+	}
+	mode := int(op>>3) & 7
+	isValid := true
+	switch op >> 12 {
+	case 0x0:
+		switch {
+		case op&0x0138 == 0x0108:
+			// MOVEP
+			in.length = 2
+		case op&0x0100 != 0:
+			// BTST/BCHG/BCLR/BSET Dn,<ea>
+			isValid = mode != 1 && add(d.ea(op, 1, next()), 1, true)
+		case op&0x0e00 == 0x0800:
+			// BTST/BCHG/BCLR/BSET #,<ea>
+			in.length = 2
+			isValid = mode != 1 && add(d.ea(op, 1, next()), 1, true)
+		case op&0x0e00 == 0x0e00:
+			// MOVES isn't on the 68000
+			isValid = false
+		default:
+			// ORI/ANDI/SUBI/ADDI/EORI/CMPI
+			size := sizeOf(op >> 6)
+			if size == 0 {
+				isValid = false
+				break
+			}
+			if op&0x3f == 0x3c {
+				// to CCR/SR, only for ORI, ANDI and EORI
+				top := op & 0x0e00
+				isValid = size < 4 && (top == 0x0000 || top == 0x0200 || top == 0x0a00)
+				in.length = 2
+				break
+			}
+			in.length += max(1, size/2)
+			isValid = mode != 1 && add(d.ea(op, size, next()), size, true)
+		}
+	case 0x1, 0x2, 0x3:
+		// MOVE/MOVEA
+		size := []int{0, 1, 4, 2}[op>>12]
+		destMode, destReg := int(op>>6)&7, int(op>>9)&7
+		isValid = add(d.ea(op, size, next()), size, true) &&
+			!(destMode == 7 && destReg > 1) && !(destMode == 1 && size == 1) &&
+			add(d.operand(destMode, destReg, size, next()), size, true)
+	case 0x4:
+		isValid = d.decodeMisc(op, pc, &in, add, next)
+	case 0x5:
+		if op&0x00c0 == 0x00c0 {
+			if mode == 1 {
+				// DBcc
+				disp, ok := d.word(pc + 2)
+				in.length = 2
+				in.flow = flowBranch
+				in.target = (pc + 2 + int(int16(disp))) & addressMask
+				isValid = ok
+			} else {
+				// Scc
+				isValid = add(d.ea(op, 1, next()), 1, true)
+			}
+		} else {
+			// ADDQ/SUBQ
+			size := sizeOf(op >> 6)
+			isValid = add(d.ea(op, size, next()), size, true)
+		}
+	case 0x6:
+		// BRA/BSR/Bcc
+		disp := int(int8(op))
+		if disp == 0 {
+			w, ok := d.word(pc + 2)
+			if !ok {
+				return illegal
+			}
+			disp = int(int16(w))
+			in.length = 2
+		}
+		in.target = (pc + 2 + disp) & addressMask
+		switch op >> 8 & 0xf {
+		case 0:
+			in.flow = flowJump
+		case 1:
+			in.flow = flowCall
+		default:
+			in.flow = flowBranch
+		}
+		isValid = in.target%2 == 0
+	case 0x7:
+		// MOVEQ
+		isValid = op&0x0100 == 0
+	case 0x8, 0xc:
+		switch {
+		case op&0x00c0 == 0x00c0:
+			// DIVU/DIVS/MULU/MULS
+			isValid = add(d.ea(op, 2, next()), 2, true)
+		case op&0x01f0 == 0x0100:
+			// SBCD/ABCD
+		case op>>12 == 0xc && (op&0x01f8 == 0x0140 || op&0x01f8 == 0x0148 || op&0x01f8 == 0x0188):
+			// EXG
+		default:
+			// OR/AND
+			size := sizeOf(op >> 6)
+			isValid = add(d.ea(op, size, next()), size, true)
+		}
+	case 0x9, 0xb, 0xd:
+		switch {
+		case op&0x00c0 == 0x00c0:
+			// SUBA/CMPA/ADDA
+			size := 2
+			if op&0x0100 != 0 {
+				size = 4
+			}
+			isValid = add(d.ea(op, size, next()), size, true)
+		case op>>12 != 0xb && op&0x0130 == 0x0100:
+			// SUBX/ADDX
+		case op>>12 == 0xb && op&0x0138 == 0x0108:
+			// CMPM
+		default:
+			// SUB/CMP/EOR/ADD
+			size := sizeOf(op >> 6)
+			isValid = add(d.ea(op, size, next()), size, true)
+		}
+	case 0xe:
+		if op&0x00c0 == 0x00c0 {
+			// memory shifts and rotates
+			isValid = op&0x0800 == 0 && mode >= 2 && add(d.ea(op, 2, next()), 2, true)
+		}
+	default:
+		// line A and line F
+		isValid = false
+	}
+	if !isValid {
+		return illegal
+	}
+	return in
+}
+
+// decodeMisc decodes the 0x4xxx instructions.
+func (d *decoder) decodeMisc(op uint16, pc int, in *instruction, add func(operand, int, bool) bool, next func() int) bool {
+	mode := int(op>>3) & 7
+	// the control addressing modes, for JMP, JSR, LEA and PEA
+	isControl := mode == 2 || mode == 5 || mode == 6 || mode == 7 && op&7 <= 3
+	switch {
+	case op == 0x4afc:
+		// ILLEGAL
+		return false
+	case op&0xfff0 == 0x4e40:
+		// TRAP, which comes back to the next instruction
+	case op&0xfff8 == 0x4e50:
+		// LINK
+		in.length = 2
+	case op&0xfff8 == 0x4e58, op&0xfff0 == 0x4e60, op == 0x4e70, op == 0x4e71, op == 0x4e76:
+		// UNLK, MOVE USP, RESET, NOP, TRAPV
+	case op == 0x4e72:
+		// STOP, which carries on once an interrupt returns
+		in.length = 2
+	case op == 0x4e73, op == 0x4e75, op == 0x4e77:
+		// RTE, RTS, RTR
+		in.flow = flowReturn
+	case op&0xff80 == 0x4e80:
+		// JSR/JMP
+		if !isControl {
+			return false
+		}
+		o := d.ea(op, 0, next())
+		in.flow = flowCall
+		if op&0x0040 != 0 {
+			in.flow = flowJump
+		}
+		in.target = o.address
+		return add(o, 0, false)
+	case op&0xf1c0 == 0x41c0:
+		// LEA
+		return isControl && add(d.ea(op, 0, next()), 0, false)
+	case op&0xf1c0 == 0x4180:
+		// CHK
+		return mode != 1 && add(d.ea(op, 2, next()), 2, true)
+	case op&0xffc0 == 0x40c0, op&0xffc0 == 0x44c0, op&0xffc0 == 0x46c0:
+		// MOVE from SR, to CCR, to SR
+		return mode != 1 && add(d.ea(op, 2, next()), 2, true)
+	case op&0xf900 == 0x4000 && op&0x00c0 != 0x00c0:
+		// NEGX/CLR/NEG/NOT
+		size := sizeOf(op >> 6)
+		return mode != 1 && add(d.ea(op, size, next()), size, true)
+	case op&0xffc0 == 0x4800:
+		// NBCD
+		return mode != 1 && add(d.ea(op, 1, next()), 1, true)
+	case op&0xfff8 == 0x4840, op&0xfff8 == 0x4880, op&0xfff8 == 0x48c0:
+		// SWAP, EXT
+	case op&0xffc0 == 0x4840:
+		// PEA
+		return isControl && add(d.ea(op, 0, next()), 0, false)
+	case op&0xfb80 == 0x4880:
+		// MOVEM, whose register mask comes before the extension words
+		in.length = 2
+		return mode >= 2 && add(d.ea(op, 0, next()), 0, false)
+	case op&0xff00 == 0x4a00 && op&0x00c0 != 0x00c0:
+		// TST
+		size := sizeOf(op >> 6)
+		return add(d.ea(op, size, next()), size, true)
+	case op&0xffc0 == 0x4ac0:
+		// TAS
+		return mode != 1 && add(d.ea(op, 1, next()), 1, true)
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package m68k
+
+// The 68000 reads its exception vectors as data, from the first 0x100 bytes.
+const vectorTableSize = 0x100
+
+// The vectors whose handlers are traced: reset, the error and trap exceptions,
+// and the interrupt autovectors.
+const (
+	resetVector = 1
+	lastVector  = 47
+)
+
+// Trace follows code flow from the reset and exception vectors of a program.
+// opcodes is the program as the CPU fetches opcodes, i.e. the decrypted
+// maincpu, and data as it reads data, i.e. the maincpu as stored in the ROM
+// files. Both are big endian.
+//
+// Code reached only through jump tables or computed addresses isn't found, so
+// words the trace doesn't reach are Unknown rather than data.
+func Trace(opcodes []uint8, data []uint8) CodeMap {
+	codeMap := make(CodeMap, len(opcodes)/2)
+	mark := func(address int, size int, access Access) {
+		for a := address &^ 1; a < address+size; a += 2 {
+			if a/2 < len(codeMap) && codeMap[a/2] < access {
+				codeMap[a/2] = access
+			}
+		}
+	}
+	mark(0, min(vectorTableSize, 2*len(codeMap)), Data)
+
+	vectors := &decoder{data}
+	var queue []int
+	for vector := resetVector; vector <= lastVector; vector++ {
+		handler, ok := vectors.long(vector * 4)
+		if ok && EntryPointIsValid(int(handler), len(opcodes)) {
+			queue = append(queue, int(handler))
+		}
+	}
+
+	d := &decoder{opcodes}
+	isStart := make([]bool, len(codeMap))
+	for len(queue) > 0 {
+		pc := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		for EntryPointIsValid(pc, len(opcodes)) && !isStart[pc/2] {
+			in := d.decode(pc)
+			if in.flow == flowIllegal {
+				break
+			}
+			isStart[pc/2] = true
+			mark(pc, 2*in.length, Opcode)
+			for _, ref := range in.references {
+				if ref.pcRelative {
+					// PC relative reads go through the opcode view
+					mark(ref.address, ref.size, PcRelative)
+				} else {
+					mark(ref.address, ref.size, Data)
+				}
+			}
+			if in.target >= 0 && in.flow != flowNext {
+				queue = append(queue, in.target)
+			}
+			if in.flow == flowJump || in.flow == flowReturn {
+				break
+			}
+			pc += 2 * in.length
+		}
+	}
+	return codeMap
+}
+
+// EntryPointIsValid reports whether address can hold an instruction of a
+// program of size bytes.
+func EntryPointIsValid(address int, size int) bool {
+	return address >= vectorTableSize && address < size && address%2 == 0
+}
+
+// LegalRun decodes up to count instructions from pc, following jumps, and
+// returns how many it got through before one was illegal or left the program.
+// Reaching a return or a jump to a computed address ends the run early, and
+// counts as getting through all of them.
+func LegalRun(opcodes []uint8, pc int, count int) int {
+	d := &decoder{opcodes}
+	for n := 0; n < count; n++ {
+		if !EntryPointIsValid(pc, len(opcodes)) {
+			return n
+		}
+		in := d.decode(pc)
+		switch {
+		case in.flow == flowIllegal:
+			return n
+		case in.flow == flowReturn, in.flow == flowJump && in.target < 0:
+			return count
+		case in.flow == flowJump:
+			pc = in.target
+		default:
+			pc += 2 * in.length
+		}
+	}
+	return count
+}
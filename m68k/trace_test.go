@@ -0,0 +1,109 @@
+package m68k
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// assemble places the words of each address in an image of size bytes.
+func assemble(size int, code map[int][]uint16) []uint8 {
+	image := make([]uint8, size)
+	for address, words := range code {
+		for i, word := range words {
+			binary.BigEndian.PutUint16(image[address+2*i:], word)
+		}
+	}
+	return image
+}
+
+// testProgram calls, branches and returns its way through most of the flow
+// the trace follows, reads a word PC relative and another absolute, and ends
+// in a jump table it can't follow.
+var testProgram = map[int][]uint16{
+	// reset vector
+	0x004: {0x0000, 0x0100},
+	// jsr $120.l
+	0x100: {0x4eb9, 0x0000, 0x0120},
+	// bsr.w $128
+	0x106: {0x6100, 0x0020},
+	// move.w ($140,pc),d0
+	0x10a: {0x303a, 0x0034},
+	// move.w $144.l,d0
+	0x10e: {0x3039, 0x0000, 0x0144},
+	// jmp (2,pc,d0.w), into a table of bra.w
+	0x114: {0x4efb, 0x0002},
+	0x118: {0x6000, 0x0036, 0x6000, 0x0032},
+	// nop, rts
+	0x120: {0x4e71, 0x4e75},
+	// bra.s $12c, over an illegal
+	0x128: {0x6002, 0x4afc},
+	// jmp ($150,pc)
+	0x12c: {0x4efa, 0x0022},
+	0x140: {0x1234},
+	0x144: {0x5678},
+	// rts
+	0x150: {0x4e75},
+}
+
+const testProgramMap = `# start-end (exclusive) access: code, pcrel, data or unknown
+0x000000-0x000100 data
+0x000100-0x000118 code
+0x000118-0x000120 unknown
+0x000120-0x000124 code
+0x000124-0x000128 unknown
+0x000128-0x00012a code
+0x00012a-0x00012c unknown
+0x00012c-0x000130 code
+0x000130-0x000140 unknown
+0x000140-0x000142 pcrel
+0x000142-0x000144 unknown
+0x000144-0x000146 data
+0x000146-0x000150 unknown
+0x000150-0x000152 code
+0x000152-0x000160 unknown
+`
+
+func TestTrace(t *testing.T) {
+	image := assemble(0x160, testProgram)
+	if got := string(Trace(image, image).Encode()); got != testProgramMap {
+		t.Errorf("code map is\n%s\nexpected\n%s", got, testProgramMap)
+	}
+}
+
+func TestTraceReadsVectorsAsData(t *testing.T) {
+	// the opcode view of the vectors is garbage, as the vectors are stored
+	// unencrypted
+	opcodes := assemble(0x120, map[int][]uint16{0x004: {0xdead, 0xbeef}, 0x110: {0x4e75}})
+	data := assemble(0x120, map[int][]uint16{0x004: {0x0000, 0x0110}})
+	codeMap := Trace(opcodes, data)
+	if codeMap[0x110/2] != Opcode {
+		t.Errorf("0x000110 is %s, expected code", codeMap[0x110/2])
+	}
+	if counts := codeMap.Counts(); counts[Opcode] != 1 {
+		t.Errorf("%d words are code, expected 1", counts[Opcode])
+	}
+}
+
+func TestLegalRun(t *testing.T) {
+	image := assemble(0x160, testProgram)
+	tests := []struct {
+		name  string
+		pc    int
+		count int
+		want  int
+	}{
+		{"computed jump", 0x100, 8, 8},
+		{"return", 0x120, 4, 4},
+		{"jump over an illegal", 0x128, 4, 4},
+		{"illegal", 0x12a, 4, 0},
+		{"into the vectors", 0x80, 4, 0},
+		{"off the end", 0x15e, 4, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := LegalRun(image, tt.pc, tt.count); got != tt.want {
+				t.Errorf("LegalRun(0x%06x, %d) is %d, expected %d", tt.pc, tt.count, got, tt.want)
+			}
+		})
+	}
+}
@@ -2,15 +2,25 @@ package main
 
 import (
 	"archive/zip"
+	"bytes"
+	"cmp"
 	_ "embed"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"image/color"
+	"image/png"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/MBDesu/mbdcps2/Resources"
 	"github.com/MBDesu/mbdcps2/cps2crypt"
 	"github.com/MBDesu/mbdcps2/cps2rom"
+	"github.com/MBDesu/mbdcps2/m68k"
 	"github.com/MBDesu/mbdcps2/tui"
 	file_utils "github.com/MBDesu/mbdcps2/utils"
 )
@@ -21,22 +31,60 @@ import (
 //
 // Then encrypt should take a `.bin` and a `.zip` as input, adding the missing files back to the `.zip`
 //
-// | Mode             | Flag | Priority | Input File Format | Output File Format | ROM set name |
-// | ---------------- | :--: | :------: | :---------------: | :----------------: | :----------: |
-// | Concat           |  c   |    5     |       .zip        |        .bin        |   Required   |
-// | Decrypt          |  d   |    1     |       .zip        |        .bin        |   Required   |
-// | Encrypt          |  e   |    2     |     .bin+.zip     |        .zip        |   Required   |
-// | Generate .mra    |  m   |    4     |       .zip        |        .mra        |   Required   |
-// | Patch            |  p   |    3     |       .zip        |        .zip        |   Required   |
-// | Decode gfx       |  g   |    6     |       .zip        |        .bin        |   Required   |
+// | Mode             |   Flag    | Priority | Input File Format | Output File Format | ROM set name |
+// | ---------------- | :-------: | :------: | :---------------: | :----------------: | :----------: |
+// | Concat           |     c     |    5     |       .zip        |        .bin        |   Optional   |
+// | Decrypt          |     d     |    1     |       .zip        |        .bin        |   Optional   |
+// | Encrypt          |     e     |    2     |     .bin+.zip     |        .zip        |   Optional   |
+// | Generate .mra    |     m     |    4     |       .zip        |        .mra        |   Optional   |
+// | Patch            |     p     |    3     |       .zip        |        .zip        |   Optional   |
+// | Decode gfx       |     g     |    6     |       .zip        |        .bin        |   Optional   |
+// | Key              |    key    |    7     |    .key/.zip      |        .key        |   Optional   |
+// | Phoenix          |  phoenix  |    8     |       .zip        |        .zip        |   Optional   |
+// | Rekey            |   rekey   |    9     |  .zip+.key/.zip   |        .zip        |   Optional   |
+// | XOR table        |    xor    |    10    |       .zip        |        .xor        |   Optional   |
+// | Recover key      |  recover  |    11    | .zip+.bin/.xor    |        .key        |   Optional   |
+// | Benchmark cipher |   bench   |    12    |    .zip/dir       |        N/A         |   Optional   |
+// | Merged image     |   merge   |    13    |       .zip        |     .bin+.map      |   Optional   |
+// | Audit            |   audit   |    14    |    .zip/dir       |        N/A         |   Optional   |
+// | Generate defs    |  genroms  |    15    |     cps2.cpp      |     roms.json      |     N/A      |
+// | Convert layout   |  convert  |    16    |       .zip        |        .zip        |   Optional   |
+// | Darksoft         | darksoft  |    17    |   .zip/folder     |    folder/.zip     |   Optional   |
+// | Unshuffle gfx    | unshuffle |    18    |       .zip        |        .bin        |   Optional   |
+// | Reshuffle gfx    | reshuffle |    19    |     .bin+.zip     |        .zip        |   Optional   |
+// | Export tiles     |  export   |    20    |       .zip        |        .png        |   Optional   |
+// | Import tiles     |  import   |    21    |     .png+.zip     |     .zip+.mra      |   Optional   |
+// | Split            |   split   |    22    |  .bin[+.zip]      |        .zip        |   Optional   |
 //
-// | Argument        | Flag |   Required With     |
-// | :-------------- | :--: | :-----------------: |
-// | Output filepath |  o   |       N/A           |
-// | Input zip       |  z   |    c, d, g, m, p    |
-// | Input bin       |  b   |       e             |
-// | ROM set name    |  n   |  c, d, e, g, m, p   |
-// | Input diff zip  |  x   |       m             |
+// | Argument        |   Flag    |   Required With     |
+// | :-------------- | :-------: | :-----------------: |
+// | Output filepath |     o     |       N/A           |
+// | Input zip       |     z     | c, d, e, m, p, phoenix, rekey, xor, recover, bench, merge, audit, convert, darksoft, unshuffle, reshuffle, export, import |
+// | Input bin       |     b     | e, w, recover, reshuffle, split |
+// | ROM set name    |     n     |       N/A           |
+// | Input diff zip  |     x     |       m             |
+// | Worker count    |     j     |       N/A           |
+// | Address range   |     a     |       N/A           |
+// | Input key file  |  keyfile  |      rekey          |
+// | Key ROM set     |  keyset   |       N/A           |
+// | Input XOR table |  xorfile  |       N/A           |
+// | Master key 1    |    key1   |       key           |
+// | Master key 2    |    key2   |       key           |
+// | Upper limit     |   limit   |       key           |
+// | JSON output     |   json    |       N/A           |
+// | Verify output   |  verify   |       N/A           |
+// | Code map        |    map    |       N/A           |
+// | Data view bin   |   data    |       N/A           |
+// | Suggest keys    |  suggest  |       N/A           |
+// | Skip checks     |   force   |       N/A           |
+// | MAME source     |   mame    |     genroms         |
+// | ROM defs overlay|   roms    |       N/A           |
+// | Set layout      |  layout   |     convert         |
+// | Tile size       |   tile    |       N/A           |
+// | Tile range      |   tiles   |       N/A           |
+// | Palette file    |  palette  |       N/A           |
+// | Tile sheet      |   sheet   |      import         |
+// | Region          |  region   |       N/A           |
 
 type Flags struct {
 	isConcatMode    bool
@@ -46,12 +94,50 @@ type Flags struct {
 	isPatchMode     bool
 	isMraMode       bool
 	isSwapMode      bool
+	isKeyMode       bool
+	isPhoenixMode   bool
+	isRekeyMode     bool
+	isXorMode       bool
+	isRecoverMode   bool
+	isBenchMode     bool
+	isMergeMode     bool
+	isAuditMode     bool
+	isGenRomsMode   bool
+	isConvertMode   bool
+	isDarksoftMode  bool
+	isUnshuffleMode bool
+	isReshuffleMode bool
+	isExportMode    bool
+	isImportMode    bool
+	isSplitMode     bool
 	romSetName      string
 	binFilepath     string
 	outputFilepath  string
 	zipFilepath     string
 	diffZipFilepath string
 	mraFilepath     string
+	workers         int
+	addressRange    string
+	keyFilepath     string
+	keySetName      string
+	xorFilepath     string
+	masterKey1      string
+	masterKey2      string
+	upperLimit      string
+	isJson          bool
+	isVerify        bool
+	mapFilepath     string
+	dataFilepath    string
+	isSuggest       bool
+	isForce         bool
+	mameFilepath    string
+	romsFilepath    string
+	layout          string
+	tileSize        int
+	tileRange       string
+	paletteFilepath string
+	sheetFilepath   string
+	regionName      string
 }
 
 var flags Flags
@@ -70,36 +156,124 @@ func parseFlags() {
 	mraFile := flag.String("r", "", Resources.Strings.Flag["mraFileDesc"])
 	diffZipFile := flag.String("x", "", Resources.Strings.Flag["diffZipDesc"])
 	zipFile := flag.String("z", "", Resources.Strings.Flag["zipFileDesc"])
+	workers := flag.Int("j", 0, Resources.Strings.Flag["workersDesc"])
+	addressRange := flag.String("a", "", Resources.Strings.Flag["rangeDesc"])
+	keyMode := flag.Bool("key", false, Resources.Strings.Flag["keyModeDesc"])
+	phoenixMode := flag.Bool("phoenix", false, Resources.Strings.Flag["phoenixModeDesc"])
+	rekeyMode := flag.Bool("rekey", false, Resources.Strings.Flag["rekeyModeDesc"])
+	keySetName := flag.String("keyset", "", Resources.Strings.Flag["keySetDesc"])
+	xorMode := flag.Bool("xor", false, Resources.Strings.Flag["xorModeDesc"])
+	recoverMode := flag.Bool("recover", false, Resources.Strings.Flag["recoverDesc"])
+	benchMode := flag.Bool("bench", false, Resources.Strings.Flag["benchModeDesc"])
+	mergeMode := flag.Bool("merge", false, Resources.Strings.Flag["mergeModeDesc"])
+	auditMode := flag.Bool("audit", false, Resources.Strings.Flag["auditModeDesc"])
+	genRomsMode := flag.Bool("genroms", false, Resources.Strings.Flag["genRomsModeDesc"])
+	convertMode := flag.Bool("convert", false, Resources.Strings.Flag["convertModeDesc"])
+	darksoftMode := flag.Bool("darksoft", false, Resources.Strings.Flag["darksoftDesc"])
+	unshuffleMode := flag.Bool("unshuffle", false, Resources.Strings.Flag["unshuffleDesc"])
+	reshuffleMode := flag.Bool("reshuffle", false, Resources.Strings.Flag["reshuffleDesc"])
+	exportMode := flag.Bool("export", false, Resources.Strings.Flag["exportDesc"])
+	importMode := flag.Bool("import", false, Resources.Strings.Flag["importDesc"])
+	sheetFile := flag.String("sheet", "", Resources.Strings.Flag["sheetDesc"])
+	splitMode := flag.Bool("split", false, Resources.Strings.Flag["splitDesc"])
+	regionName := flag.String("region", "", Resources.Strings.Flag["regionDesc"])
+	tileSize := flag.Int("tile", 0, Resources.Strings.Flag["tileDesc"])
+	tileRange := flag.String("tiles", "", Resources.Strings.Flag["tilesDesc"])
+	paletteFile := flag.String("palette", "", Resources.Strings.Flag["paletteDesc"])
+	layout := flag.String("layout", "", Resources.Strings.Flag["layoutDesc"])
+	mameFile := flag.String("mame", "", Resources.Strings.Flag["mameFileDesc"])
+	romsFile := flag.String("roms", "", Resources.Strings.Flag["romsFileDesc"])
+	mapFile := flag.String("map", "", Resources.Strings.Flag["mapFileDesc"])
+	dataFile := flag.String("data", "", Resources.Strings.Flag["dataFileDesc"])
+	suggest := flag.Bool("suggest", false, Resources.Strings.Flag["suggestDesc"])
+	force := flag.Bool("force", false, Resources.Strings.Flag["forceDesc"])
+	xorFile := flag.String("xorfile", "", Resources.Strings.Flag["xorFileDesc"])
+	keyFile := flag.String("keyfile", "", Resources.Strings.Flag["keyFileDesc"])
+	masterKey1 := flag.String("key1", "", Resources.Strings.Flag["masterKey1Desc"])
+	masterKey2 := flag.String("key2", "", Resources.Strings.Flag["masterKey2Desc"])
+	upperLimit := flag.String("limit", "", Resources.Strings.Flag["limitDesc"])
+	jsonOutput := flag.Bool("json", false, Resources.Strings.Flag["jsonDesc"])
+	verify := flag.Bool("verify", false, Resources.Strings.Flag["verifyDesc"])
 
 	flag.Parse()
-	flags = Flags{*concatMode, *decryptMode, *encryptMode, *guiMode, *patchMode, *diffMode, *swapMode, *romName, *binFile, *outputFile, *zipFile, *diffZipFile, *mraFile}
+	flags = Flags{*concatMode, *decryptMode, *encryptMode, *guiMode, *patchMode, *diffMode, *swapMode, *keyMode, *phoenixMode, *rekeyMode, *xorMode, *recoverMode, *benchMode, *mergeMode, *auditMode, *genRomsMode, *convertMode, *darksoftMode, *unshuffleMode, *reshuffleMode, *exportMode, *importMode, *splitMode, *romName, *binFile, *outputFile, *zipFile, *diffZipFile, *mraFile, *workers, *addressRange, *keyFile, *keySetName, *xorFile, *masterKey1, *masterKey2, *upperLimit, *jsonOutput, *verify, *mapFile, *dataFile, *suggest, *force, *mameFile, *romsFile, *layout, *tileSize, *tileRange, *paletteFile, *sheetFile, *regionName}
+	Resources.Quiet = flags.isJson
+	loadRomOverlays()
 	validateFlags()
 }
 
+// loadRomOverlays adds the ROM set definitions of the roms flag input to the
+// built in ones and those in the overlay directory
+func loadRomOverlays() {
+	if flags.romsFilepath == "" {
+		return
+	}
+	romSetNames, err := cps2rom.LoadRomOverlays(flags.romsFilepath)
+	check(err)
+	Resources.Logger.Info(fmt.Sprintf("Loaded %d ROM set definitions from %s: %s", len(romSetNames), filepath.Clean(flags.romsFilepath), strings.Join(romSetNames, ", ")))
+}
+
 func validateFlags() {
 	if flags.isGuiMode {
 		return
 	}
-	zipFileRequired := flags.isDecryptMode || flags.isEncryptMode || flags.isMraMode || flags.isPatchMode || flags.isConcatMode
+	zipFileRequired := flags.isDecryptMode || flags.isEncryptMode || flags.isMraMode || flags.isPatchMode || flags.isConcatMode || flags.isPhoenixMode || flags.isRekeyMode || flags.isXorMode || flags.isRecoverMode || flags.isBenchMode || flags.isMergeMode || flags.isAuditMode || flags.isConvertMode || flags.isDarksoftMode || flags.isUnshuffleMode || flags.isReshuffleMode || flags.isExportMode || flags.isImportMode
 	if zipFileRequired && flags.zipFilepath == "" {
 		flag.Usage()
 		throw(Resources.Strings.Error["noRomFile"])
 	}
-	binFileRequired := flags.isEncryptMode || flags.isSwapMode
+	binFileRequired := flags.isEncryptMode || flags.isSwapMode || flags.isReshuffleMode || flags.isSplitMode || (flags.isRecoverMode && flags.xorFilepath == "")
 	if binFileRequired && flags.binFilepath == "" {
 		flag.Usage()
 		throw(Resources.Strings.Error["noBinFile"])
 	}
-	romSetNameRequired := flags.isDecryptMode || flags.isEncryptMode || flags.isMraMode || flags.isPatchMode || flags.isConcatMode
+	romSetNameRequired := flags.isDecryptMode || flags.isEncryptMode || flags.isMraMode || flags.isPatchMode || flags.isConcatMode || flags.isPhoenixMode || flags.isRekeyMode || flags.isXorMode || flags.isRecoverMode || flags.isMergeMode || flags.isConvertMode || flags.isUnshuffleMode || flags.isReshuffleMode || flags.isExportMode || flags.isImportMode || (flags.isSplitMode && flags.zipFilepath != "") || (flags.isDarksoftMode && !cps2rom.IsDarksoftDir(flags.zipFilepath)) || (flags.isKeyMode && flags.zipFilepath != "")
 	if romSetNameRequired && flags.romSetName == "" {
-		flag.Usage()
-		throw(Resources.Strings.Error["noRomSetName"])
+		flags.romSetName = identifyRomSet(flags.zipFilepath)
 	}
 	diffZipFileRequired := flags.isMraMode
 	if diffZipFileRequired && flags.diffZipFilepath == "" {
 		flag.Usage()
 		throw(Resources.Strings.Error["noDiffRomFile"])
 	}
+	keyFileRequired := flags.isRekeyMode
+	if keyFileRequired && flags.keyFilepath == "" {
+		flag.Usage()
+		throw(Resources.Strings.Error["noKeyFile"])
+	}
+	keySetNameRequired := isRomSetPath(flags.keyFilepath)
+	if keySetNameRequired && flags.keySetName == "" {
+		flags.keySetName = identifyRomSet(flags.keyFilepath)
+	}
+	mameFileRequired := flags.isGenRomsMode
+	if mameFileRequired && flags.mameFilepath == "" {
+		flag.Usage()
+		throw(Resources.Strings.Error["noMameFile"])
+	}
+	layoutRequired := flags.isConvertMode
+	if layoutRequired && !slices.Contains(cps2rom.Layouts, flags.layout) {
+		flag.Usage()
+		throw(Resources.Strings.Error["noLayout"])
+	}
+	flags.tileSize = cmp.Or(flags.tileSize, 16)
+	if flags.isSplitMode && flags.romSetName == "" {
+		flag.Usage()
+		throw(Resources.Strings.Error["noRomSetName"])
+	}
+	flags.regionName = cmp.Or(flags.regionName, "maincpu")
+	if flags.isSplitMode && !slices.Contains(cps2rom.RegionNames, flags.regionName) {
+		flag.Usage()
+		throw(Resources.Strings.Error["badRegion"])
+	}
+	sheetFileRequired := flags.isImportMode
+	if sheetFileRequired && flags.sheetFilepath == "" {
+		flag.Usage()
+		throw(Resources.Strings.Error["noSheetFile"])
+	}
+	if (flags.isExportMode || flags.isImportMode) && !slices.Contains(cps2rom.TileSizes, flags.tileSize) {
+		flag.Usage()
+		throw(Resources.Strings.Error["badTileSize"])
+	}
 	mraFileRequired := flags.isPatchMode
 	if mraFileRequired && flags.mraFilepath == "" {
 		flag.Usage()
@@ -107,6 +281,30 @@ func validateFlags() {
 	}
 }
 
+// identifyRomSet works out which ROM set a ROM .zip holds from its files. When
+// the key isn't needed, a set that only misses its key will do.
+func identifyRomSet(zipFilepath string) string {
+	Resources.Logger.Warn(fmt.Sprintf("Identifying %s...", filepath.Clean(zipFilepath)))
+	romZipFile, err := cps2rom.OpenRomSource(zipFilepath)
+	check(err)
+	defer romZipFile.Close()
+	romSetName, err := cps2rom.IdentifyRomSet(romZipFile, zipFilepath)
+	var unidentifiedErr *cps2rom.UnidentifiedError
+	isKeyOptional := zipFilepath == flags.zipFilepath && (flags.xorFilepath != "" || flags.isRecoverMode)
+	if isKeyOptional && errors.As(err, &unidentifiedErr) && len(unidentifiedErr.Candidates) > 0 {
+		best := unidentifiedErr.Candidates[0]
+		key := cps2rom.GetRomDefinition(best.RomSetName).Key
+		onlyKeyIsMissing := len(best.WrongSize) == 0 && len(best.Missing) == 1 && len(key.Operations) > 0 && best.Missing[0] == key.Operations[0].Filename
+		isTied := len(unidentifiedErr.Candidates) > 1 && len(unidentifiedErr.Candidates[1].Missing)+len(unidentifiedErr.Candidates[1].WrongSize) <= 1
+		if onlyKeyIsMissing && !isTied {
+			Resources.Logger.Info(fmt.Sprintf("Identified ROM set %s, without its key", best.RomSetName))
+			romSetName, err = best.RomSetName, nil
+		}
+	}
+	check(err)
+	return romSetName
+}
+
 func throw(errorString string) {
 	fmt.Println(Resources.LogText.Red(Resources.LogText.Bold("[!]")) + " " + errorString)
 	os.Exit(1)
@@ -147,6 +345,202 @@ func concat() {
 	f.Close()
 }
 
+// unshuffleGfx writes the gfx region as MAME decodes it
+func unshuffleGfx() {
+	if flags.outputFilepath == "" {
+		flags.outputFilepath = flags.romSetName + "_gfx.bin"
+	}
+	romZipFile, romDef, err := cps2rom.ParseRomZip(flags.zipFilepath, flags.romSetName)
+	check(err)
+	defer romZipFile.Close()
+	linearGfx := readLinearGfx(romZipFile, romDef)
+	err = file_utils.WriteBytesToFile(flags.outputFilepath, linearGfx)
+	check(err)
+	Resources.Logger.Done(fmt.Sprintf("Unshuffled gfx written to %s!", flags.outputFilepath))
+}
+
+// reshuffleGfx shuffles the b flag input back into the gfx files of the z
+// flag input
+func reshuffleGfx() {
+	if flags.outputFilepath == "" {
+		flags.outputFilepath = flags.romSetName + ".zip"
+	}
+	romZipFile, romDef, err := cps2rom.ParseRomZip(flags.zipFilepath, flags.romSetName)
+	check(err)
+	defer romZipFile.Close()
+	linearGfx, err := file_utils.GetFileContents(flags.binFilepath)
+	check(err)
+	if len(linearGfx) != romDef.Gfx.Size {
+		throw(fmt.Sprintf("%s is 0x%x bytes, expected a gfx region of 0x%x", flags.binFilepath, len(linearGfx), romDef.Gfx.Size))
+	}
+	Resources.Logger.Warn("Reshuffling gfx...")
+	gfxBinary, err := cps2rom.ReshuffleGfx(linearGfx)
+	check(err)
+	err = cps2rom.SplitRegionToFiles(romDef.Gfx, gfxBinary, flags.outputFilepath+"_gfx")
+	check(err)
+	gfxZip, err := cps2rom.OpenRomSource(flags.outputFilepath + "_gfx")
+	check(err)
+	err = cps2rom.WriteModifiedRegionToZip(flags.outputFilepath, romZipFile, gfxZip, romDef.Gfx)
+	check(err)
+	// the temporary .zip can only be deleted once it's closed on Windows
+	gfxZip.Close()
+	err = file_utils.DeleteFile(flags.outputFilepath + "_gfx")
+	check(err)
+	Resources.Logger.Done(fmt.Sprintf("Reshuffled ROM written to %s!", flags.outputFilepath))
+}
+
+// readLinearGfx returns the unshuffled gfx region of a ROM
+func readLinearGfx(romZipFile *cps2rom.RomSource, romDef *cps2rom.RomDefinition) []byte {
+	gfxBinary, err := cps2rom.ProcessRegionFromZip(romZipFile, romDef.Gfx)
+	check(err)
+	Resources.Logger.Warn("Unshuffling gfx...")
+	linearGfx, err := cps2rom.UnshuffleGfx(gfxBinary)
+	check(err)
+	return linearGfx
+}
+
+// parseTileRange returns the tiles flag's range, or every tile of a gfx region
+// of gfxSize bytes
+func parseTileRange(gfxSize int) (int, int) {
+	if flags.tileRange == "" {
+		return 0, cps2rom.TileCount(gfxSize, flags.tileSize)
+	}
+	start, end, err := parseAddressRange(flags.tileRange)
+	if err != nil {
+		throw(Resources.Strings.Error["badTileRange"])
+	}
+	if !strings.Contains(flags.tileRange, ":") {
+		end = start + 1
+	}
+	return start, end
+}
+
+// export draws the tiles of the gfx region to a PNG sheet
+func export() {
+	if flags.outputFilepath == "" {
+		flags.outputFilepath = fmt.Sprintf("%s_%dx%d.png", flags.romSetName, flags.tileSize, flags.tileSize)
+	}
+	palette := readPalette()
+	romZipFile, romDef, err := cps2rom.ParseRomZip(flags.zipFilepath, flags.romSetName)
+	check(err)
+	defer romZipFile.Close()
+	linearGfx := readLinearGfx(romZipFile, romDef)
+	start, end := parseTileRange(len(linearGfx))
+	Resources.Logger.Warn(fmt.Sprintf("Drawing %dx%d tiles 0x%x to 0x%x...", flags.tileSize, flags.tileSize, start, end))
+	sheet, err := cps2rom.TileSheet(linearGfx, flags.tileSize, start, end, palette)
+	check(err)
+	f, err := file_utils.CreateFile(flags.outputFilepath)
+	check(err)
+	err = png.Encode(f, sheet)
+	check(err)
+	check(f.Close())
+	Resources.Logger.Done(fmt.Sprintf("Tile sheet written to %s!", flags.outputFilepath))
+}
+
+// readPalette returns the palette flag's colors, or grays
+func readPalette() color.Palette {
+	if flags.paletteFilepath == "" {
+		return cps2rom.GrayscalePalette()
+	}
+	paletteContents, err := file_utils.GetFileContents(flags.paletteFilepath)
+	check(err)
+	palette, err := cps2rom.ReadPalette(paletteContents)
+	check(err)
+	return palette
+}
+
+// importTiles encodes the tiles of a PNG sheet into the gfx files of the z flag
+// input, and writes .mra patches of the changes
+func importTiles() {
+	if flags.outputFilepath == "" {
+		flags.outputFilepath = flags.romSetName + "_gfx.zip"
+	}
+	mraFilepath := strings.TrimSuffix(flags.outputFilepath, filepath.Ext(flags.outputFilepath)) + ".mra"
+	sheetFile, err := os.Open(flags.sheetFilepath)
+	check(err)
+	sheet, err := png.Decode(sheetFile)
+	sheetFile.Close()
+	check(err)
+	romZipFile, romDef, err := cps2rom.ParseRomZip(flags.zipFilepath, flags.romSetName)
+	check(err)
+	defer romZipFile.Close()
+	linearGfx := readLinearGfx(romZipFile, romDef)
+	start, end := parseTileRange(len(linearGfx))
+	if !strings.Contains(flags.tileRange, ":") {
+		end = start
+	}
+	Resources.Logger.Warn(fmt.Sprintf("Encoding %dx%d tiles from 0x%x...", flags.tileSize, flags.tileSize, start))
+	count, quantized, err := cps2rom.ImportTileSheet(linearGfx, flags.tileSize, start, end-start, sheet, readPalette())
+	check(err)
+	if quantized > 0 {
+		Resources.Logger.Warn(fmt.Sprintf("%d pixels weren't one of the palette's colors and were given the nearest", quantized))
+	}
+	Resources.Logger.Info(fmt.Sprintf("Encoded tiles 0x%x to 0x%x", start, start+count))
+	gfxBinary, err := cps2rom.ReshuffleGfx(linearGfx)
+	check(err)
+	err = cps2rom.SplitRegionToFiles(romDef.Gfx, gfxBinary, flags.outputFilepath+"_gfx")
+	check(err)
+	gfxZip, err := cps2rom.OpenRomSource(flags.outputFilepath + "_gfx")
+	check(err)
+	err = cps2rom.WriteModifiedRegionToZip(flags.outputFilepath, romZipFile, gfxZip, romDef.Gfx)
+	check(err)
+	Resources.Logger.Done(fmt.Sprintf("Imported ROM written to %s!", flags.outputFilepath))
+	regions := mraRegions(romDef)
+	gfx := regions[slices.IndexFunc(regions, func(region mraRegion) bool { return region.regionName == "gfx" })]
+	Resources.Logger.Warn("Diffing gfx...")
+	Resources.Logger.Info(fmt.Sprintf("%s (+0x%06x):", gfx.regionName, gfx.baseOffset))
+	patches, err := cps2rom.DiffRomRegion(gfx.baseOffset, gfx.region, romZipFile, gfxZip)
+	check(err)
+	// the temporary .zip can only be deleted once it's closed on Windows
+	gfxZip.Close()
+	err = file_utils.DeleteFile(flags.outputFilepath + "_gfx")
+	check(err)
+	writeMraPatches(*patches, mraFilepath)
+}
+
+// split splits the b flag input, a region as concat writes it, back into the
+// ROM set's files
+func split() {
+	romDef, ok := (*cps2rom.RomDefinitions)[flags.romSetName]
+	if !ok {
+		throw(fmt.Sprintf("ROM set %s is invalid or unsupported", flags.romSetName))
+	}
+	region, _ := romDef.Region(flags.regionName)
+	if len(region.Operations) == 0 {
+		throw(fmt.Sprintf("ROM set %s has no %s region", flags.romSetName, flags.regionName))
+	}
+	regionBinary, err := file_utils.GetFileContents(flags.binFilepath)
+	check(err)
+	if flags.zipFilepath == "" {
+		if flags.outputFilepath == "" {
+			flags.outputFilepath = flags.romSetName + "_" + flags.regionName + ".zip"
+		}
+		Resources.Logger.Warn(fmt.Sprintf("Splitting %s into %s files...", filepath.Clean(flags.binFilepath), flags.regionName))
+		err = cps2rom.SplitRegionToFiles(region, regionBinary, flags.outputFilepath)
+		check(err)
+		Resources.Logger.Done(fmt.Sprintf("%s files written to %s!", flags.regionName, flags.outputFilepath))
+		return
+	}
+	if flags.outputFilepath == "" {
+		flags.outputFilepath = flags.romSetName + ".zip"
+	}
+	romZipFile, _, err := cps2rom.ParseRomZip(flags.zipFilepath, flags.romSetName)
+	check(err)
+	defer romZipFile.Close()
+	Resources.Logger.Warn(fmt.Sprintf("Splitting %s into %s files...", filepath.Clean(flags.binFilepath), flags.regionName))
+	err = cps2rom.SplitRegionToFiles(region, regionBinary, flags.outputFilepath+"_split")
+	check(err)
+	regionZip, err := cps2rom.OpenRomSource(flags.outputFilepath + "_split")
+	check(err)
+	err = cps2rom.WriteModifiedRegionToZip(flags.outputFilepath, romZipFile, regionZip, region)
+	check(err)
+	// the temporary .zip can only be deleted once it's closed on Windows
+	regionZip.Close()
+	err = file_utils.DeleteFile(flags.outputFilepath + "_split")
+	check(err)
+	Resources.Logger.Done(fmt.Sprintf("Split ROM written to %s!", flags.outputFilepath))
+}
+
 // func decodeGfx() {
 // 	if flags.outputFilepath == "" || flags.outputFilepath == flags.romSetName+".bin" {
 // 		flags.outputFilepath = flags.romSetName + "_gfx.bin"
@@ -166,19 +560,26 @@ func decrypt(args ...*string) {
 		flags.romSetName = *args[0]
 		flags.zipFilepath = *args[1]
 	}
-	if flags.outputFilepath == "" {
+	if flags.outputFilepath == "" && flags.addressRange == "" {
 		flags.outputFilepath = flags.romSetName + ".bin"
 	}
-	romZipFile, romDef, err := cps2rom.ParseRomZip(flags.zipFilepath, flags.romSetName)
-	check(err)
+	romZipFile, romDef := parseRomZipForCrypt()
 	defer romZipFile.Close()
 	romBinary, err := cps2rom.ProcessRegionFromZip(romZipFile, romDef.Maincpu)
 	check(err)
-	decryptedRomBinary, err := cps2crypt.Crypt(cps2crypt.Decrypt, *romDef, romZipFile, romBinary)
+	if flags.addressRange != "" {
+		cryptRange(cps2crypt.Decrypt, romDef, romZipFile, romBinary)
+		return
+	}
+	decryptedRomBinary, err := cryptMaincpu(cps2crypt.Decrypt, romDef, romZipFile, romBinary)
 	check(err)
+	checkDecrypted(romBinary, decryptedRomBinary)
 	err = file_utils.WriteBytesToFile(flags.outputFilepath, decryptedRomBinary)
 	check(err)
 	Resources.Logger.Done(fmt.Sprintf("Decrypted ROM written to %s!", flags.outputFilepath))
+	if flags.isVerify {
+		verifyCrypt(cps2crypt.Decrypt, romDef, romZipFile, romBinary, decryptedRomBinary, 0)
+	}
 }
 
 func encrypt(args ...*string) {
@@ -187,19 +588,29 @@ func encrypt(args ...*string) {
 		flags.zipFilepath = *args[1]
 		flags.binFilepath = *args[2]
 	}
-	if flags.outputFilepath == "" {
+	if flags.outputFilepath == "" && flags.addressRange == "" {
 		flags.outputFilepath = flags.romSetName + ".zip"
 	}
-	romZipFile, romDef, err := cps2rom.ParseRomZip(flags.zipFilepath, flags.romSetName)
-	check(err)
+	romZipFile, romDef := parseRomZipForCrypt()
 	decryptedRomBinary, err := file_utils.GetFileContents(flags.binFilepath)
 	check(err)
 	defer romZipFile.Close()
-	encryptedRegion, err := cps2crypt.Crypt(cps2crypt.Encrypt, *romDef, romZipFile, decryptedRomBinary)
-	check(err)
-	err = cps2rom.SplitRegionToFiles(romDef.Maincpu, encryptedRegion, flags.outputFilepath+"_enc")
+	if flags.addressRange != "" {
+		cryptRange(cps2crypt.Encrypt, romDef, romZipFile, decryptedRomBinary)
+		return
+	}
+	isViews := flags.mapFilepath != "" || flags.dataFilepath != ""
+	var encryptedRegion []byte
+	if isViews {
+		encryptedRegion = encryptViews(romDef, romZipFile, decryptedRomBinary)
+	} else {
+		encryptedRegion, err = cryptMaincpu(cps2crypt.Encrypt, romDef, romZipFile, decryptedRomBinary)
+		check(err)
+	}
+	// the encrypted words are byte swapped, the region is big endian
+	err = cps2rom.SplitRegionToFiles(romDef.Maincpu, file_utils.SwapBytes(slices.Clone(encryptedRegion)), flags.outputFilepath+"_enc")
 	check(err)
-	encryptedRegionZip, err := zip.OpenReader(flags.outputFilepath + "_enc")
+	encryptedRegionZip, err := cps2rom.OpenRomSource(flags.outputFilepath + "_enc")
 	check(err)
 	defer encryptedRegionZip.Close()
 	err = cps2rom.WriteModifiedRegionToZip(flags.outputFilepath, romZipFile, encryptedRegionZip, romDef.Maincpu)
@@ -207,6 +618,652 @@ func encrypt(args ...*string) {
 	err = file_utils.DeleteFile(flags.outputFilepath + "_enc")
 	check(err)
 	Resources.Logger.Done(fmt.Sprintf("Encrypted ROM written to %s!", flags.outputFilepath))
+	if flags.isVerify {
+		// read the new maincpu files back, so splitting and zipping are checked too
+		encryptedZipFile, err := cps2rom.OpenRomSource(flags.outputFilepath)
+		check(err)
+		defer encryptedZipFile.Close()
+		encryptedRomBinary, err := cps2rom.ProcessRegionFromZip(encryptedZipFile, romDef.Maincpu)
+		check(err)
+		if isViews {
+			// data words aren't encrypted, so there's no round trip to check
+			Resources.Logger.Warn("Verifying written maincpu...")
+			check(cps2crypt.CompareImages(file_utils.SwapBytes(slices.Clone(encryptedRegion)), encryptedRomBinary, 0))
+			Resources.Logger.Done("Written maincpu OK!")
+		} else {
+			verifyCrypt(cps2crypt.Encrypt, romDef, romZipFile, decryptedRomBinary, encryptedRomBinary, 0)
+		}
+	}
+}
+
+// encryptViews encrypts the b flag input only where the CPU fetches it as
+// opcodes, according to the code map, and stores the data view as is
+// everywhere else. Without a data view, the b flag input is a merged image
+// like the merge flag writes.
+func encryptViews(romDef *cps2rom.RomDefinition, romZipFile *cps2rom.RomSource, binary []byte) []byte {
+	romBinary, err := cps2rom.ProcessRegionFromZip(romZipFile, romDef.Maincpu)
+	check(err)
+	decryptedRomBinary, err := cryptMaincpu(cps2crypt.Decrypt, romDef, romZipFile, romBinary)
+	check(err)
+	original := cps2crypt.Views{Opcodes: decryptedRomBinary, Data: romBinary}
+	var codeMap m68k.CodeMap
+	if flags.mapFilepath != "" {
+		Resources.Logger.Info(fmt.Sprintf("Using code map %s", flags.mapFilepath))
+		mapText, err := file_utils.GetFileContents(flags.mapFilepath)
+		check(err)
+		codeMap, err = m68k.ParseCodeMap(mapText, len(romBinary))
+		check(err)
+	}
+	var edited cps2crypt.Views
+	if flags.dataFilepath != "" {
+		dataBinary, err := file_utils.GetFileContents(flags.dataFilepath)
+		check(err)
+		edited = cps2crypt.Views{Opcodes: binary, Data: dataBinary}
+	} else {
+		edited = cps2crypt.SplitMergedImage(binary, original, codeMap)
+	}
+	encryptedOpcodes, err := cryptMaincpu(cps2crypt.Encrypt, romDef, romZipFile, edited.Opcodes)
+	check(err)
+	encryptedRegion, ambiguous, err := cps2crypt.ResolveViews(encryptedOpcodes, edited, original, codeMap)
+	check(err)
+	for _, r := range ambiguous {
+		Resources.Logger.Error(fmt.Sprintf("0x%06x-0x%06x was edited, but the code map doesn't say whether it's code or data", r.Start, r.End))
+	}
+	if len(ambiguous) > 0 {
+		Resources.Logger.Warn("Edits the code map doesn't cover are only encrypted where the opcode view changed, check them in game")
+	}
+	return encryptedRegion
+}
+
+// parseRomZipForCrypt parses the z flag input, which doesn't need a key when
+// an XOR table is used instead or the key is being recovered
+func parseRomZipForCrypt() (*cps2rom.RomSource, *cps2rom.RomDefinition) {
+	romZipFile, romDef, err := cps2rom.ParseRomZip(flags.zipFilepath, flags.romSetName)
+	var missingFilesErr *cps2rom.MissingFilesError
+	if (flags.xorFilepath != "" || flags.isRecoverMode) && errors.As(err, &missingFilesErr) && len(romDef.Key.Operations) > 0 {
+		onlyKeyIsMissing := len(missingFilesErr.Files) == 1 && missingFilesErr.Files[0] == romDef.Key.Operations[0].Filename
+		if onlyKeyIsMissing {
+			err = nil
+		}
+	}
+	check(err)
+	return romZipFile, romDef
+}
+
+func cryptMaincpu(direction cps2crypt.Direction, romDef *cps2rom.RomDefinition, romZipFile *cps2rom.RomSource, romBinary []byte) ([]byte, error) {
+	if flags.xorFilepath == "" {
+		return cps2crypt.Crypt(direction, *romDef, romZipFile, romBinary, flags.workers)
+	}
+	Resources.Logger.Info(fmt.Sprintf("Using XOR table %s", flags.xorFilepath))
+	xorTable, err := file_utils.GetFileContents(flags.xorFilepath)
+	if err != nil {
+		return nil, err
+	}
+	return cps2crypt.CryptWithXorTable(direction, romBinary, xorTable), nil
+}
+
+// checkDecrypted stops if a decrypted maincpu doesn't look like a 68000
+// program, which means the ROM set name is most likely wrong, unless the force
+// flag is given. With the suggest flag, the keys of other ROM sets are tried
+// to tell which it could be.
+func checkDecrypted(romBinary []byte, decryptedRomBinary []byte) {
+	err := cps2crypt.CheckDecrypted(romBinary, decryptedRomBinary)
+	if err == nil {
+		return
+	}
+	var implausibleErr *cps2crypt.ImplausibleError
+	if flags.isSuggest && errors.As(err, &implausibleErr) {
+		Resources.Logger.Warn("Trying the keys of other ROM sets...")
+		implausibleErr.Candidates = cps2crypt.FindPlausibleKeys(romBinary, findKeys(), flags.workers)
+	}
+	if flags.isForce {
+		Resources.Logger.Error(err.Error())
+		return
+	}
+	check(err)
+}
+
+// findKeys reads the keys of every other supported ROM set that has one in the
+// z flag input, or in a .zip of its own next to it
+func findKeys() map[string]*cps2crypt.Key {
+	keys := map[string]*cps2crypt.Key{}
+	romZipFile, err := cps2rom.OpenRomSource(flags.zipFilepath)
+	check(err)
+	defer romZipFile.Close()
+	for romSetName, romDef := range *cps2rom.RomDefinitions {
+		if romSetName == flags.romSetName {
+			continue
+		}
+		keyBytes, err := cps2crypt.ReadKeyFromZip(romDef, romZipFile)
+		if err != nil {
+			keyZipFilepath := cps2rom.FindRomSetPath(filepath.Dir(filepath.Clean(flags.zipFilepath)), romSetName)
+			if keyZipFilepath == "" {
+				continue
+			}
+			keyZipFile, err := cps2rom.OpenRomSource(keyZipFilepath)
+			if err != nil {
+				continue
+			}
+			keyBytes, err = cps2crypt.ReadKeyFromZip(romDef, keyZipFile)
+			keyZipFile.Close()
+			if err != nil {
+				continue
+			}
+		}
+		if key, err := cps2crypt.NewKey(keyBytes); err == nil {
+			keys[romSetName] = key
+		}
+	}
+	return keys
+}
+
+// verifyCrypt crypts output, big endian like input, back the other way and
+// compares it with input
+func verifyCrypt(direction cps2crypt.Direction, romDef *cps2rom.RomDefinition, romZipFile *cps2rom.RomSource, input []byte, output []byte, start int) {
+	Resources.Logger.Warn("Verifying round trip...")
+	if flags.xorFilepath != "" {
+		xorTable, err := file_utils.GetFileContents(flags.xorFilepath)
+		check(err)
+		check(cps2crypt.VerifyXorRoundTrip(input, output, xorTable, start))
+	} else {
+		cipher, err := cps2crypt.LoadCipher(*romDef, romZipFile, flags.workers)
+		check(err)
+		check(cipher.VerifyRoundTrip(direction, input, output, start))
+	}
+	Resources.Logger.Done("Round trip OK!")
+}
+
+func parseAddressRange(addressRange string) (int, int, error) {
+	startString, endString, isRange := strings.Cut(addressRange, ":")
+	start, err := strconv.ParseInt(startString, 0, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	end := start + 2
+	if isRange {
+		end, err = strconv.ParseInt(endString, 0, 32)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return int(start), int(end), nil
+}
+
+func cryptRange(direction cps2crypt.Direction, romDef *cps2rom.RomDefinition, romZipFile *cps2rom.RomSource, romBinary []byte) {
+	start, end, err := parseAddressRange(flags.addressRange)
+	if err != nil {
+		throw(Resources.Strings.Error["badRange"])
+	}
+	var rangeBinary []byte
+	if flags.xorFilepath != "" {
+		if start%2 != 0 || end%2 != 0 || start < 0 || start >= end || end > len(romBinary) {
+			throw(Resources.Strings.Error["badRange"])
+		}
+		xorTable, err := file_utils.GetFileContents(flags.xorFilepath)
+		check(err)
+		rangeBinary = cps2crypt.ApplyXorTable(romBinary[start:end], xorTable[min(start, len(xorTable)):min(end, len(xorTable))])
+	} else {
+		cipher, err := cps2crypt.LoadCipher(*romDef, romZipFile, flags.workers)
+		check(err)
+		rangeBinary, err = cipher.CryptRange(direction, romBinary, start, end)
+		check(err)
+	}
+	if flags.isVerify {
+		verifyCrypt(direction, romDef, romZipFile, romBinary[start:end], rangeBinary, start)
+	}
+	if flags.outputFilepath != "" {
+		err = file_utils.WriteBytesToFile(flags.outputFilepath, rangeBinary)
+		check(err)
+		Resources.Logger.Done(fmt.Sprintf("0x%06x-0x%06x written to %s!", start, end, flags.outputFilepath))
+		return
+	}
+	for i := 0; i < len(rangeBinary); i += 2 {
+		fmt.Printf("0x%06x: %02x%02x\n", start+i, rangeBinary[i], rangeBinary[i+1])
+	}
+}
+
+func phoenix() {
+	if flags.outputFilepath == "" {
+		flags.outputFilepath = flags.romSetName + "_phoenix.zip"
+	}
+	romZipFile, romDef, err := cps2rom.ParseRomZip(flags.zipFilepath, flags.romSetName)
+	check(err)
+	defer romZipFile.Close()
+	romBinary, err := cps2rom.ProcessRegionFromZip(romZipFile, romDef.Maincpu)
+	check(err)
+	decryptedRomBinary, err := cps2crypt.Crypt(cps2crypt.Decrypt, *romDef, romZipFile, romBinary, flags.workers)
+	check(err)
+	checkDecrypted(romBinary, decryptedRomBinary)
+	nullKey, err := cps2crypt.NewNoEncryptionKey().Encode()
+	check(err)
+	err = cps2rom.SplitRegionToFiles(romDef.Maincpu, decryptedRomBinary, flags.outputFilepath+"_dec")
+	check(err)
+	err = cps2rom.SplitRegionToFiles(romDef.Key, nullKey, flags.outputFilepath+"_key")
+	check(err)
+	maincpuZip, err := cps2rom.OpenRomSource(flags.outputFilepath + "_dec")
+	check(err)
+	keyZip, err := cps2rom.OpenRomSource(flags.outputFilepath + "_key")
+	check(err)
+	err = cps2rom.WriteModifiedRegionsToZip(flags.outputFilepath, romZipFile, []*cps2rom.RomSource{maincpuZip, keyZip}, []cps2rom.RomRegion{romDef.Maincpu, romDef.Key})
+	check(err)
+	maincpuZip.Close()
+	keyZip.Close()
+	err = file_utils.DeleteFile(flags.outputFilepath + "_dec")
+	check(err)
+	err = file_utils.DeleteFile(flags.outputFilepath + "_key")
+	check(err)
+	Resources.Logger.Warn("Checking phoenix set...")
+	err = checkPhoenix(romDef, decryptedRomBinary)
+	check(err)
+	Resources.Logger.Done(fmt.Sprintf("Phoenix ROM written to %s!", flags.outputFilepath))
+}
+
+func rekey() {
+	if flags.outputFilepath == "" {
+		flags.outputFilepath = flags.romSetName + "_rekey.zip"
+	}
+	romZipFile, romDef, err := cps2rom.ParseRomZip(flags.zipFilepath, flags.romSetName)
+	check(err)
+	defer romZipFile.Close()
+	romBinary, err := cps2rom.ProcessRegionFromZip(romZipFile, romDef.Maincpu)
+	check(err)
+	decryptedRomBinary, err := cps2crypt.Crypt(cps2crypt.Decrypt, *romDef, romZipFile, romBinary, flags.workers)
+	check(err)
+	checkDecrypted(romBinary, decryptedRomBinary)
+	Resources.Logger.Warn(fmt.Sprintf("Reading target key from %s...", flags.keyFilepath))
+	keyBytes, err := readKeyFile(flags.keyFilepath, flags.keySetName)
+	check(err)
+	targetKey, err := cps2crypt.NewKey(keyBytes)
+	check(err)
+	printKeyInfo(targetKey.Info())
+	targetCipher := cps2crypt.NewCipher(targetKey)
+	targetCipher.Workers = flags.workers
+	// the encrypted words are byte swapped, the region is big endian
+	encryptedRegion := file_utils.SwapBytes(targetCipher.Encrypt(decryptedRomBinary))
+	err = cps2rom.SplitRegionToFiles(romDef.Maincpu, encryptedRegion, flags.outputFilepath+"_enc")
+	check(err)
+	err = cps2rom.SplitRegionToFiles(romDef.Key, keyBytes[:cps2crypt.KeyLength], flags.outputFilepath+"_key")
+	check(err)
+	encryptedRegionZip, err := cps2rom.OpenRomSource(flags.outputFilepath + "_enc")
+	check(err)
+	keyZip, err := cps2rom.OpenRomSource(flags.outputFilepath + "_key")
+	check(err)
+	err = cps2rom.WriteModifiedRegionsToZip(flags.outputFilepath, romZipFile, []*cps2rom.RomSource{encryptedRegionZip, keyZip}, []cps2rom.RomRegion{romDef.Maincpu, romDef.Key})
+	check(err)
+	encryptedRegionZip.Close()
+	keyZip.Close()
+	err = file_utils.DeleteFile(flags.outputFilepath + "_enc")
+	check(err)
+	err = file_utils.DeleteFile(flags.outputFilepath + "_key")
+	check(err)
+	Resources.Logger.Done(fmt.Sprintf("Rekeyed ROM written to %s!", flags.outputFilepath))
+}
+
+func xor() {
+	if flags.outputFilepath == "" {
+		flags.outputFilepath = flags.romSetName + ".xor"
+	}
+	romZipFile, romDef, err := cps2rom.ParseRomZip(flags.zipFilepath, flags.romSetName)
+	check(err)
+	defer romZipFile.Close()
+	romBinary, err := cps2rom.ProcessRegionFromZip(romZipFile, romDef.Maincpu)
+	check(err)
+	decryptedRomBinary, err := cps2crypt.Crypt(cps2crypt.Decrypt, *romDef, romZipFile, romBinary, flags.workers)
+	check(err)
+	checkDecrypted(romBinary, decryptedRomBinary)
+	xorTable, err := cps2crypt.NewXorTable(romBinary, decryptedRomBinary)
+	check(err)
+	err = file_utils.WriteBytesToFile(flags.outputFilepath, xorTable)
+	check(err)
+	Resources.Logger.Done(fmt.Sprintf("XOR table written to %s!", flags.outputFilepath))
+}
+
+func recoverKey() {
+	if flags.outputFilepath == "" {
+		flags.outputFilepath = flags.romSetName + ".key"
+	}
+	romZipFile, romDef := parseRomZipForCrypt()
+	defer romZipFile.Close()
+	romBinary, err := cps2rom.ProcessRegionFromZip(romZipFile, romDef.Maincpu)
+	check(err)
+	var decryptedRomBinary []byte
+	if flags.xorFilepath != "" {
+		xorTable, err := file_utils.GetFileContents(flags.xorFilepath)
+		check(err)
+		decryptedRomBinary = cps2crypt.ApplyXorTable(romBinary[:min(len(romBinary), len(xorTable))], xorTable)
+	} else {
+		decryptedRomBinary, err = file_utils.GetFileContents(flags.binFilepath)
+		check(err)
+	}
+	Resources.Logger.Warn("Recovering key...")
+	cps2Key, err := cps2crypt.RecoverKey(romBinary, decryptedRomBinary, flags.workers)
+	check(err)
+	printKeyInfo(cps2Key.Info())
+	keyBytes, err := cps2Key.Encode()
+	check(err)
+	err = file_utils.WriteBytesToFile(flags.outputFilepath, keyBytes)
+	check(err)
+	Resources.Logger.Done(fmt.Sprintf("Key written to %s!", flags.outputFilepath))
+}
+
+func merge() {
+	if flags.outputFilepath == "" {
+		flags.outputFilepath = flags.romSetName + "_merged.bin"
+	}
+	if flags.mapFilepath == "" {
+		flags.mapFilepath = flags.romSetName + ".map"
+	}
+	romZipFile, romDef := parseRomZipForCrypt()
+	defer romZipFile.Close()
+	romBinary, err := cps2rom.ProcessRegionFromZip(romZipFile, romDef.Maincpu)
+	check(err)
+	decryptedRomBinary, err := cryptMaincpu(cps2crypt.Decrypt, romDef, romZipFile, romBinary)
+	check(err)
+	checkDecrypted(romBinary, decryptedRomBinary)
+	Resources.Logger.Warn("Tracing code...")
+	codeMap := m68k.Trace(decryptedRomBinary, romBinary)
+	counts := codeMap.Counts()
+	Resources.Logger.Info(fmt.Sprintf("%d code, %d PC relative, %d data and %d unknown words", counts[m68k.Opcode], counts[m68k.PcRelative], counts[m68k.Data], counts[m68k.Unknown]))
+	err = file_utils.WriteBytesToFile(flags.outputFilepath, cps2crypt.MergeImages(romBinary, decryptedRomBinary, codeMap))
+	check(err)
+	err = file_utils.WriteBytesToFile(flags.mapFilepath, codeMap.Encode())
+	check(err)
+	Resources.Logger.Done(fmt.Sprintf("Merged ROM written to %s, code map to %s!", flags.outputFilepath, flags.mapFilepath))
+}
+
+type benchResult struct {
+	RomSetName  string `json:"romSetName"`
+	ReferenceMs int64  `json:"referenceMs"`
+	TableMs     int64  `json:"tableMs"`
+	Identical   bool   `json:"identical"`
+	Problem     string `json:"problem,omitempty"`
+}
+
+// benchSet times the reference and table driven ciphers on the maincpu of a
+// ROM set, quietly since a directory may hold many sets
+func benchSet(zipFilepath string, romSetName string) benchResult {
+	result := benchResult{RomSetName: romSetName}
+	quiet := Resources.Quiet
+	Resources.Quiet = true
+	defer func() {
+		Resources.Quiet = quiet
+	}()
+	romZipFile, romDef, err := cps2rom.ParseRomZip(zipFilepath, romSetName)
+	if err != nil {
+		result.Problem = err.Error()
+		return result
+	}
+	defer romZipFile.Close()
+	romBinary, err := cps2rom.ProcessRegionFromZip(romZipFile, romDef.Maincpu)
+	if err != nil {
+		result.Problem = err.Error()
+		return result
+	}
+	cipher, err := cps2crypt.LoadCipher(*romDef, romZipFile, flags.workers)
+	if err != nil {
+		result.Problem = err.Error()
+		return result
+	}
+	benchmark, err := cps2crypt.BenchmarkCipher(&cipher.Key, romBinary, flags.workers)
+	result.ReferenceMs = benchmark.ReferenceTime.Milliseconds()
+	result.TableMs = benchmark.TableTime.Milliseconds()
+	result.Identical = err == nil
+	if err != nil {
+		result.Problem = err.Error()
+	}
+	return result
+}
+
+// findRomSetZips finds the .zip or directory of every supported ROM set, or
+// only of the n flag's, in directory
+func findRomSetZips(directory string) ([]string, map[string]string) {
+	var romSetNames []string
+	zipFilepaths := map[string]string{}
+	for romSetName := range *cps2rom.RomDefinitions {
+		zipFilepath := cps2rom.FindRomSetPath(directory, romSetName)
+		if zipFilepath != "" && (flags.romSetName == "" || flags.romSetName == romSetName) {
+			romSetNames = append(romSetNames, romSetName)
+			zipFilepaths[romSetName] = zipFilepath
+		}
+	}
+	slices.Sort(romSetNames)
+	return romSetNames, zipFilepaths
+}
+
+func bench() {
+	var romSetNames []string
+	zipFilepaths := map[string]string{}
+	info, err := os.Stat(flags.zipFilepath)
+	check(err)
+	if info.IsDir() {
+		romSetNames, zipFilepaths = findRomSetZips(flags.zipFilepath)
+	}
+	// a directory without ROM sets in it may be a ROM set itself
+	if len(romSetNames) == 0 {
+		if flags.romSetName == "" {
+			flags.romSetName = identifyRomSet(flags.zipFilepath)
+		}
+		romSetNames = []string{flags.romSetName}
+		zipFilepaths[flags.romSetName] = flags.zipFilepath
+	}
+	results := make([]benchResult, 0, len(romSetNames))
+	failed := 0
+	for _, romSetName := range romSetNames {
+		result := benchSet(zipFilepaths[romSetName], romSetName)
+		results = append(results, result)
+		if result.Problem != "" {
+			failed++
+			Resources.Logger.Error(fmt.Sprintf("%s: %s", romSetName, result.Problem))
+			continue
+		}
+		Resources.Logger.Done(fmt.Sprintf("%s: reference %dms, table driven %dms, output identical", romSetName, result.ReferenceMs, result.TableMs))
+	}
+	if flags.isJson {
+		printJson(results)
+	}
+	if failed > 0 {
+		throw(fmt.Sprintf("%d of %d ROM sets failed", failed, len(results)))
+	}
+}
+
+// auditSet audits a ROM set .zip. Without a ROM set name, the .zip is audited
+// as the ROM set it's closest to.
+func auditSet(zipFilepath string, romSetName string) cps2rom.SetAudit {
+	result := cps2rom.SetAudit{RomSetName: romSetName, Verdict: cps2rom.VerdictBad}
+	romZipFile, err := cps2rom.OpenRomSource(zipFilepath)
+	if err != nil {
+		result.Problem = err.Error()
+		return result
+	}
+	defer romZipFile.Close()
+	if romSetName == "" {
+		matches := cps2rom.IdentifyRomZip(romZipFile, zipFilepath)
+		if len(matches) == 0 {
+			result.Problem = (&cps2rom.UnidentifiedError{}).Error()
+			return result
+		}
+		romSetName = matches[0].RomSetName
+		result.RomSetName = romSetName
+	}
+	romDef, ok := (*cps2rom.RomDefinitions)[romSetName]
+	if !ok {
+		result.Problem = fmt.Sprintf("ROM set %s is invalid or unsupported", romSetName)
+		return result
+	}
+	_, err = cps2rom.AddParentFiles(romZipFile, romDef)
+	if err != nil {
+		result.Problem = err.Error()
+		return result
+	}
+	audit, err := cps2rom.AuditRomZip(romSetName, romDef, romZipFile)
+	if err != nil {
+		result.Problem = err.Error()
+		return result
+	}
+	return audit
+}
+
+func printAudit(audit cps2rom.SetAudit) {
+	if audit.Problem != "" {
+		Resources.Logger.Error(fmt.Sprintf("%s: %s", cmp.Or(audit.RomSetName, filepath.Base(flags.zipFilepath)), audit.Problem))
+		return
+	}
+	summary := fmt.Sprintf("%s: %s (%s)", audit.RomSetName, audit.Verdict, audit.Summary())
+	switch audit.Verdict {
+	case cps2rom.VerdictGood:
+		Resources.Logger.Done(summary)
+	case cps2rom.VerdictUnverified:
+		Resources.Logger.Warn(summary)
+	default:
+		Resources.Logger.Error(summary)
+	}
+	for _, file := range audit.Files {
+		detail := ""
+		switch file.Status {
+		case cps2rom.FileGood, cps2rom.FileUnverified:
+			continue
+		case cps2rom.FileWrongSize:
+			detail = fmt.Sprintf(", 0x%x bytes, expected 0x%x", file.Size, file.Expected.Size)
+		case cps2rom.FileBadDump:
+			detail = fmt.Sprintf(", crc %s sha1 %s, expected crc %s sha1 %s", file.Crc, file.Sha1, file.Expected.Crc, file.Expected.Sha1)
+		case cps2rom.FileWrongRevision:
+			detail = fmt.Sprintf(", is %s", file.Is)
+		}
+		Resources.Logger.Info(fmt.Sprintf("  %s: %s%s", file.Filename, file.Status, detail))
+	}
+}
+
+func audit() {
+	romSetNames := []string{flags.romSetName}
+	zipFilepaths := map[string]string{flags.romSetName: flags.zipFilepath}
+	info, err := os.Stat(flags.zipFilepath)
+	check(err)
+	// a directory without ROM sets in it may be a ROM set itself
+	if info.IsDir() {
+		if dirRomSetNames, dirZipFilepaths := findRomSetZips(flags.zipFilepath); len(dirRomSetNames) > 0 {
+			romSetNames, zipFilepaths = dirRomSetNames, dirZipFilepaths
+		}
+	}
+	audits := make([]cps2rom.SetAudit, 0, len(romSetNames))
+	bad, unverified := 0, 0
+	for _, romSetName := range romSetNames {
+		Resources.Logger.Warn(fmt.Sprintf("Auditing %s...", filepath.Clean(zipFilepaths[romSetName])))
+		audit := auditSet(zipFilepaths[romSetName], romSetName)
+		audits = append(audits, audit)
+		printAudit(audit)
+		if audit.Verdict == cps2rom.VerdictBad {
+			bad++
+		}
+		unverified += audit.Counts()[cps2rom.FileUnverified]
+	}
+	if unverified > 0 {
+		Resources.Logger.Warn(fmt.Sprintf("%d files were only checked by size, their definitions have no hashes. Regenerate the definitions from MAME's cps2.cpp with -genroms to check them", unverified))
+	}
+	if flags.isJson {
+		printJson(audits)
+	}
+	if bad > 0 {
+		throw(fmt.Sprintf("%d of %d ROM sets are bad", bad, len(audits)))
+	}
+}
+
+func genRoms() {
+	if flags.outputFilepath == "" {
+		flags.outputFilepath = "roms.json"
+	}
+	Resources.Logger.Warn(fmt.Sprintf("Parsing %s...", filepath.Clean(flags.mameFilepath)))
+	source, err := file_utils.GetFileContents(flags.mameFilepath)
+	check(err)
+	roms, err := cps2rom.ParseMameDriver(source)
+	check(err)
+	if len(roms) == 0 {
+		throw(fmt.Sprintf(Resources.Strings.Error["noRomDefs"], flags.mameFilepath))
+	}
+	j, err := json.MarshalIndent(roms, "", "  ")
+	check(err)
+	err = file_utils.WriteBytesToFile(flags.outputFilepath, append(j, '\n'))
+	check(err)
+	Resources.Logger.Done(fmt.Sprintf("%d ROM set definitions written to %s!", len(roms), flags.outputFilepath))
+}
+
+func convert() {
+	if flags.outputFilepath == "" {
+		romSetName := flags.romSetName
+		if parent := cps2rom.GetRomDefinition(romSetName).Parent; flags.layout == cps2rom.LayoutMerged && parent != "" {
+			romSetName = parent
+		}
+		flags.outputFilepath = romSetName + "_" + flags.layout + ".zip"
+	}
+	Resources.Logger.Warn(fmt.Sprintf("Converting %s to a %s set...", filepath.Clean(flags.zipFilepath), flags.layout))
+	err := cps2rom.ConvertRomZip(flags.romSetName, flags.zipFilepath, flags.layout, flags.outputFilepath)
+	check(err)
+	Resources.Logger.Done(fmt.Sprintf("Converted ROM set written to %s!", flags.outputFilepath))
+}
+
+// darksoft converts a MAME ROM set to a Darksoft game folder, or a Darksoft
+// game folder back to a MAME ROM .zip
+func darksoft() {
+	if !cps2rom.IsDarksoftDir(flags.zipFilepath) {
+		if flags.outputFilepath == "" {
+			flags.outputFilepath = flags.romSetName
+		}
+		romZipFile, romDef, err := cps2rom.ParseRomZip(flags.zipFilepath, flags.romSetName)
+		check(err)
+		defer romZipFile.Close()
+		Resources.Logger.Warn(fmt.Sprintf("Converting %s to a Darksoft game folder...", filepath.Clean(flags.zipFilepath)))
+		err = cps2rom.WriteDarksoftDir(romZipFile, *romDef, flags.outputFilepath)
+		check(err)
+		Resources.Logger.Done(fmt.Sprintf("Darksoft game folder written to %s!", flags.outputFilepath))
+		return
+	}
+	if flags.romSetName == "" {
+		flags.romSetName = filepath.Base(filepath.Clean(flags.zipFilepath))
+	}
+	if flags.outputFilepath == "" {
+		flags.outputFilepath = flags.romSetName + ".zip"
+	}
+	Resources.Logger.Warn(fmt.Sprintf("Converting Darksoft game folder %s to a MAME ROM .zip...", filepath.Clean(flags.zipFilepath)))
+	err := cps2rom.ReadDarksoftDir(flags.romSetName, flags.zipFilepath, flags.outputFilepath)
+	check(err)
+	romZipFile, _, err := cps2rom.ParseRomZip(flags.outputFilepath, flags.romSetName)
+	check(err)
+	romZipFile.Close()
+	Resources.Logger.Done(fmt.Sprintf("MAME ROM .zip written to %s!", flags.outputFilepath))
+}
+
+func checkPhoenix(romDef *cps2rom.RomDefinition, decryptedRomBinary []byte) error {
+	phoenixZipFile, err := cps2rom.OpenRomSource(flags.outputFilepath)
+	if err != nil {
+		return err
+	}
+	defer phoenixZipFile.Close()
+	err = cps2rom.ValidateRomZip(*romDef, phoenixZipFile)
+	if err != nil {
+		return err
+	}
+	phoenixBinary, err := cps2rom.ProcessRegionFromZip(phoenixZipFile, romDef.Maincpu)
+	if err != nil {
+		return err
+	}
+	// the region is larger than its files, the rest was never encrypted
+	for _, operation := range romDef.Maincpu.Operations {
+		start, end := operation.Offset, operation.Offset+operation.Length
+		if !bytes.Equal(phoenixBinary[start:end], decryptedRomBinary[start:end]) {
+			return errors.New(Resources.Strings.Error["phoenixMain"])
+		}
+	}
+	keyBytes, err := cps2crypt.ReadKeyFromZip(*romDef, phoenixZipFile)
+	if err != nil {
+		return err
+	}
+	key, err := cps2crypt.NewKey(keyBytes)
+	if err != nil {
+		return err
+	}
+	if !key.IsNoEncryption() {
+		return errors.New(Resources.Strings.Error["phoenixKey"])
+	}
+	return nil
 }
 
 func patch(args ...*string) {
@@ -224,7 +1281,7 @@ func patch(args ...*string) {
 	check(err)
 	mra, err := cps2rom.ParseMra(mraFile)
 	check(err)
-	fileContentMap, err := file_utils.UnzipFilesToFilenameContentMap(romZipFile)
+	fileContentMap, err := romZipFile.ReadFiles()
 	check(err)
 	Resources.Logger.Warn("Patching ROM...")
 	romRegions := []struct {
@@ -270,25 +1327,41 @@ func diff(args ...*string) {
 	check(err)
 	secondRom, _, err := cps2rom.ParseRomZip(flags.diffZipFilepath, flags.romSetName)
 	check(err)
-	romRegions := []struct {
-		regionName string
-		region     cps2rom.RomRegion
-	}{{"maincpu", romDef.Maincpu}, {"audiocpu", romDef.Audiocpu}, {"qsound", romDef.Qsound}, {"gfx", romDef.Gfx}}
-	baseOffset := 0
 	Resources.Logger.Warn("Diffing ROMs...")
-	for _, region := range romRegions {
-		Resources.Logger.Info(fmt.Sprintf("%s (+0x%06x):", region.regionName, baseOffset))
-		regionPatches, err := cps2rom.DiffRomRegion(baseOffset, region.region, firstRom, secondRom)
+	for _, region := range mraRegions(romDef) {
+		Resources.Logger.Info(fmt.Sprintf("%s (+0x%06x):", region.regionName, region.baseOffset))
+		regionPatches, err := cps2rom.DiffRomRegion(region.baseOffset, region.region, firstRom, secondRom)
 		check(err)
 		patches = append(patches, *regionPatches...)
-		if region.regionName == "audiocpu" {
+	}
+	writeMraPatches(patches, flags.outputFilepath)
+}
+
+type mraRegion struct {
+	regionName string
+	region     cps2rom.RomRegion
+	baseOffset int
+}
+
+// mraRegions returns the regions .mra patches cover, with where each starts
+// in the ROM an .mra loads
+func mraRegions(romDef *cps2rom.RomDefinition) []mraRegion {
+	regions := []mraRegion{{"maincpu", romDef.Maincpu, 0}, {"audiocpu", romDef.Audiocpu, 0}, {"qsound", romDef.Qsound, 0}, {"gfx", romDef.Gfx, 0}}
+	baseOffset := 0
+	for i := range regions {
+		regions[i].baseOffset = baseOffset
+		if regions[i].regionName == "audiocpu" {
 			baseOffset += 0x40000
 		} else {
-			baseOffset += region.region.Size
+			baseOffset += regions[i].region.Size
 		}
 	}
+	return regions
+}
+
+func writeMraPatches(patches []cps2rom.RomPatch, mraFilepath string) {
 	patchStrings := cps2rom.GenerateMraPatches(&patches)
-	patchFile, err := file_utils.CreateFile(flags.outputFilepath)
+	patchFile, err := file_utils.CreateFile(mraFilepath)
 	check(err)
 	_, err = patchFile.WriteString(Resources.Strings.Info["mraHeader"])
 	check(err)
@@ -297,7 +1370,118 @@ func diff(args ...*string) {
 		check(err)
 	}
 	defer patchFile.Close()
-	Resources.Logger.Done(fmt.Sprintf("Patches written to %s!", flags.outputFilepath))
+	Resources.Logger.Done(fmt.Sprintf("Patches written to %s!", mraFilepath))
+}
+
+func parseHex(hex string, bitSize int) uint64 {
+	value, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(hex), "0x"), 16, bitSize)
+	if err != nil {
+		throw(fmt.Sprintf(Resources.Strings.Error["badHex"], hex))
+	}
+	return value
+}
+
+func printJson(v any) {
+	j, err := json.MarshalIndent(v, "", "  ")
+	check(err)
+	fmt.Println(string(j))
+}
+
+func printKeyInfo(info cps2crypt.KeyInfo) {
+	if flags.isJson {
+		printJson(info)
+		return
+	}
+	Resources.Logger.Info(fmt.Sprintf("Master key 1 = %s", info.MasterKey1))
+	Resources.Logger.Info(fmt.Sprintf("Master key 2 = %s", info.MasterKey2))
+	Resources.Logger.Info(fmt.Sprintf("Encrypted range = %s-%s", info.LowerLimit, info.UpperLimit))
+	if info.NoEncryption {
+		Resources.Logger.Warn("No encryption key, maincpu is left as is")
+	}
+	if !info.Consistent {
+		Resources.Logger.Error(info.Problem)
+	} else {
+		Resources.Logger.Done("Key OK")
+	}
+}
+
+// isRomSetPath reports whether romSetPath is a ROM .zip or directory, rather
+// than a single file like a .key
+func isRomSetPath(romSetPath string) bool {
+	if strings.HasSuffix(strings.ToLower(romSetPath), ".zip") {
+		return true
+	}
+	info, err := os.Stat(romSetPath)
+	return err == nil && info.IsDir()
+}
+
+// readKeyFile reads a .key file, or the key of keySetName if keyFilepath is a
+// ROM .zip or directory
+func readKeyFile(keyFilepath string, keySetName string) ([]byte, error) {
+	if !isRomSetPath(keyFilepath) {
+		return file_utils.GetFileContents(keyFilepath)
+	}
+	keyZipFile, keyRomDef, err := cps2rom.ParseRomZip(keyFilepath, keySetName)
+	if err != nil {
+		return nil, err
+	}
+	defer keyZipFile.Close()
+	return cps2crypt.ReadKeyFromZip(*keyRomDef, keyZipFile)
+}
+
+func key() {
+	var cps2Key *cps2crypt.Key
+	if flags.keyFilepath != "" {
+		keyBytes, err := readKeyFile(flags.keyFilepath, flags.keySetName)
+		check(err)
+		cps2Key, err = cps2crypt.NewKey(keyBytes)
+		check(err)
+	} else if flags.zipFilepath != "" {
+		romZipFile, romDef, err := cps2rom.ParseRomZip(flags.zipFilepath, flags.romSetName)
+		check(err)
+		defer romZipFile.Close()
+		keyBytes, err := cps2crypt.ReadKeyFromZip(*romDef, romZipFile)
+		check(err)
+		cps2Key, err = cps2crypt.NewKey(keyBytes)
+		check(err)
+	}
+	isModified := flags.masterKey1 != "" || flags.masterKey2 != "" || flags.upperLimit != ""
+	if cps2Key == nil {
+		if flags.masterKey1 == "" || flags.masterKey2 == "" || flags.upperLimit == "" {
+			flag.Usage()
+			throw(Resources.Strings.Error["noKeyParams"])
+		}
+		var err error
+		masterKey := [2]uint32{uint32(parseHex(flags.masterKey1, 32)), uint32(parseHex(flags.masterKey2, 32))}
+		cps2Key, err = cps2crypt.NewKeyFromMasterKeys(masterKey, int64(parseHex(flags.upperLimit, 32)))
+		check(err)
+	} else {
+		if flags.masterKey1 != "" {
+			cps2Key.MasterKey[0] = uint32(parseHex(flags.masterKey1, 32))
+		}
+		if flags.masterKey2 != "" {
+			cps2Key.MasterKey[1] = uint32(parseHex(flags.masterKey2, 32))
+		}
+		if flags.upperLimit != "" {
+			cps2Key.LowerLimit = 0
+			cps2Key.UpperLimit = int64(parseHex(flags.upperLimit, 32))
+		}
+	}
+	printKeyInfo(cps2Key.Info())
+	if !isModified {
+		return
+	}
+	keyBytes, err := cps2Key.Encode()
+	check(err)
+	if flags.outputFilepath == "" {
+		flags.outputFilepath = "cps2.key"
+		if flags.romSetName != "" {
+			flags.outputFilepath = flags.romSetName + ".key"
+		}
+	}
+	err = file_utils.WriteBytesToFile(flags.outputFilepath, keyBytes)
+	check(err)
+	Resources.Logger.Done(fmt.Sprintf("Key written to %s!", flags.outputFilepath))
 }
 
 func swap() {
@@ -329,6 +1513,38 @@ func main() {
 		concat()
 	} else if flags.isSwapMode {
 		swap()
+	} else if flags.isKeyMode {
+		key()
+	} else if flags.isPhoenixMode {
+		phoenix()
+	} else if flags.isRekeyMode {
+		rekey()
+	} else if flags.isXorMode {
+		xor()
+	} else if flags.isRecoverMode {
+		recoverKey()
+	} else if flags.isBenchMode {
+		bench()
+	} else if flags.isMergeMode {
+		merge()
+	} else if flags.isAuditMode {
+		audit()
+	} else if flags.isGenRomsMode {
+		genRoms()
+	} else if flags.isConvertMode {
+		convert()
+	} else if flags.isDarksoftMode {
+		darksoft()
+	} else if flags.isUnshuffleMode {
+		unshuffleGfx()
+	} else if flags.isReshuffleMode {
+		reshuffleGfx()
+	} else if flags.isExportMode {
+		export()
+	} else if flags.isImportMode {
+		importTiles()
+	} else if flags.isSplitMode {
+		split()
 	}
 	os.Exit(0)
 }
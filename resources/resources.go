@@ -29,28 +29,79 @@ type Log struct {
 }
 
 var flagStrings = map[string]string{
-	"concatModeDesc":  "-z </path/to/ROM.zip> -n <ROM set name> [-o </path/to/output/file.bin>]\nConcatenation mode. Concatenates the maincpu region into a single binary file\n",
-	"decryptModeDesc": "-z </path/to/ROM.zip> -n <ROM set name> [-o </path/to/output/file.bin>]\nDecrypt mode. Decrypts a ROM's opcodes. Output is a concatenation of the decrypted binary .bin\n",
-	"encryptModeDesc": "-b </path/to/decrypted.bin> -z </path/to/ROM.zip> -n <ROM set name> [-o </path/to/output/file.zip>]\nEncrypt mode. Encrypts a ROM's opcodes. Output is a full ROM .zip\n",
+	"concatModeDesc":  "-z </path/to/ROM.zip> [-n <ROM set name>] [-o </path/to/output/file.bin>]\nConcatenation mode. Concatenates the maincpu region into a single binary file\n",
+	"decryptModeDesc": "-z </path/to/ROM.zip> [-n <ROM set name>] [-o </path/to/output/file.bin>]\nDecrypt mode. Decrypts a ROM's opcodes. Output is a concatenation of the decrypted binary .bin\n",
+	"encryptModeDesc": "-b </path/to/decrypted.bin> -z </path/to/ROM.zip> [-n <ROM set name>] [-map </path/to/file.map>] [-data </path/to/data.bin>] [-o </path/to/output/file.zip>]\nEncrypt mode. Encrypts a ROM's opcodes. Output is a full ROM .zip\n",
 	"guiModeDesc":     "Provides an interactive TUI so you don't have to bother with all of these flags\n",
-	"patchModeDesc":   "-z </path/to/ROM.zip> -n <ROM set name> [-o </path/to/output/file.zip]\nPatch mode. Patches a ROM .zip with a .mra file's <patch>es. Output is a full ROM .zip\n",
-	"diffModeDesc":    "-z </path/to/ROM.zip> -x </path/to/modified/ROM.zip> -n <ROM set name> [-o </path/to/output/file.zip>]\nDiff mode. Diffs two ROMs of the same ROM set and produces a file with .mra style patches in it. Output is said .mra file\n",
+	"patchModeDesc":   "-z </path/to/ROM.zip> [-n <ROM set name>] [-o </path/to/output/file.zip]\nPatch mode. Patches a ROM .zip with a .mra file's <patch>es. Output is a full ROM .zip\n",
+	"diffModeDesc":    "-z </path/to/ROM.zip> -x </path/to/modified/ROM.zip> [-n <ROM set name>] [-o </path/to/output/file.zip>]\nDiff mode. Diffs two ROMs of the same ROM set and produces a file with .mra style patches in it. Output is said .mra file\n",
 	"swapModeDesc":    "-b </path/to/file.bin> [-o </path/to/output/file.bin>]\nSwap mode. Swaps every byte of a binary .bin\n",
-	"romSetNameDesc":  "Specifies the ROM set name for the ROM set you are working with. Usually the .zip filename. Required with the c, d, e, m, p flags\n",
-	"binFileDesc":     "Specifies an input .bin file. Required with the e flag\n",
+	"romsFileDesc":    "Specifies a .json of extra ROM set definitions, shaped like cps2rom/roms.json, or a directory of them. They replace built in definitions of the same name. Definitions in mbdcps2/roms in the user config directory are always loaded. Optional\n",
+	"romSetNameDesc":  "Specifies the ROM set name for the ROM set you are working with. Usually the .zip filename. Optional, by default the ROM set is identified from the names and sizes of the z flag input's files\n",
+	"binFileDesc":     "Specifies an input .bin file. Required with the e, reshuffle and split flags, and with the recover flag without xorfile\n",
 	"outputFileDesc":  "Specifies an output file path. Optional\n",
-	"zipFileDesc":     "Specifies an input ROM .zip. Required with c, d, m, p flags\n",
-	"diffZipDesc":     "Specifies an input ROM .zip to diff against the z flag for generating .mra patches. Required with the m flag\n",
+	"zipFileDesc":     "Specifies an input ROM .zip, or a directory of its loose files like MAME's rompath allows. Required with c, d, m, p flags\n",
+	"diffZipDesc":     "Specifies an input ROM .zip or directory to diff against the z flag for generating .mra patches. Required with the m flag\n",
 	"mraFileDesc":     "Specifies an input .mra to patch the z flag input with. Required with the p flag\n",
+	"rangeDesc":       "<start>[:<end>]\nSpecifies a byte address, or a range up to but not including end, for the d and e flags to process instead of the whole ROM. Results are printed, or written as a .bin if o is given. Optional\n",
+	"keyModeDesc":     "[-keyfile </path/to/file.key> | -z </path/to/ROM.zip> -n <ROM set name>] [-key1 <hex>] [-key2 <hex>] [-limit <hex>] [-o </path/to/output/file.key>]\nKey mode. Prints a key's master keys and encrypted address range and checks its consistency. If key1, key2 or limit are given, writes a new key built from them and the input key, if any\n",
+	"phoenixModeDesc": "-z </path/to/ROM.zip> [-n <ROM set name>] [-o </path/to/output/file.zip>]\nPhoenix mode. Decrypts a ROM's maincpu and replaces its key with a null key so it runs on a board with a dead battery. Output is a full ROM .zip\n",
+	"rekeyModeDesc":   "-z </path/to/ROM.zip> [-n <ROM set name>] -keyfile </path/to/target.key|/path/to/target/ROM.zip> [-keyset <target ROM set name>] [-o </path/to/output/file.zip>]\nRekey mode. Decrypts a ROM's maincpu with its own key and encrypts it with the target key, for running it on a board keyed for another game. Output is a full ROM .zip with the target key\n",
+	"xorModeDesc":     "-z </path/to/ROM.zip> [-n <ROM set name>] [-o </path/to/output/file.xor>]\nXOR mode. Writes the maincpu XOR table, the decrypted binary XORed with the encrypted one, as a big endian .xor\n",
+	"recoverDesc":     "-z </path/to/ROM.zip> [-n <ROM set name>] [-b </path/to/decrypted.bin> | -xorfile </path/to/table.xor>] [-o </path/to/output/file.key>]\nRecover mode. Recovers the key of a ROM whose maincpu is encrypted from known decrypted words, such as a decrypted dump or an XOR table, which must span at least 0x160002 bytes. The key may be missing from the z flag input. Output is a .key\n",
+	"benchModeDesc":   "-z </path/to/ROM.zip|/path/to/ROM/directory> [-n <ROM set name>]\nBenchmark mode. Decrypts and encrypts a ROM's maincpu with both the reference and the table driven cipher, timing them and checking their output is identical. Given a directory, does so for every supported ROM set with a .zip in it\n",
+	"mergeModeDesc":   "-z </path/to/ROM.zip> [-n <ROM set name>] [-o </path/to/output/file.bin>] [-map </path/to/output/file.map>]\nMerge mode. Traces the decrypted maincpu's code from its reset and interrupt vectors and writes the program as the CPU sees it, decrypted where it's fetched as code and as stored where it's read as data, with a map of which words are which\n",
+	"auditModeDesc":   "-z </path/to/ROM.zip|/path/to/ROM/directory> [-n <ROM set name>] [-json]\nAudit mode. Checks every file of a ROM set against the sizes and CRC32/SHA1 hashes of its definition, reporting missing files, wrong sizes, bad dumps, files from other revisions and extra files, with a verdict for the set. Given a directory, does so for every supported ROM set with a .zip in it\n",
+	"genRomsModeDesc": "-mame </path/to/mame/src/mame/capcom/cps2.cpp> [-o </path/to/output/roms.json>]\nGenerate ROM definitions mode. Reads the ROM_START...ROM_END definitions of a local copy of MAME's CPS2 driver into ROM set definitions like the built in ones. Output is a roms.json, which replaces cps2rom/roms.json on the next build\n",
+	"convertModeDesc": "-z </path/to/ROM.zip> [-n <ROM set name>] -layout <split|merged|nonmerged> [-o </path/to/output/file.zip>]\nConvert mode. Rewrites a ROM .zip as a split set, with only the files a clone doesn't share with its parent, a merged set, the parent with the files of all its clones, or a non-merged set, with all of its files. Files the .zip doesn't have are taken from the .zips or directories of its parent and clones next to it\n",
+	"darksoftDesc":    "-z </path/to/ROM.zip|/path/to/Darksoft/game/folder> [-n <ROM set name>] [-o </path/to/output/folder|/path/to/output/file.zip>]\nDarksoft mode. Converts a MAME ROM set to a game folder for the Darksoft CPS2 multi cart, or a Darksoft game folder back to a MAME ROM .zip. A folder's ROM set is its name unless n is given\n",
+	"unshuffleDesc":   "-z </path/to/ROM.zip> [-n <ROM set name>] [-o </path/to/output/file.bin>]\nUnshuffle mode. Writes a ROM's gfx region unshuffled, as MAME decodes it, with its 4bpp tiles one after another\n",
+	"reshuffleDesc":   "-b </path/to/unshuffled.bin> -z </path/to/ROM.zip> [-n <ROM set name>] [-o </path/to/output/file.zip>]\nReshuffle mode. Shuffles an unshuffled gfx image, e.g. from the unshuffle flag, back into the gfx files of a ROM. Output is a full ROM .zip\n",
+	"exportDesc":      "-z </path/to/ROM.zip> [-n <ROM set name>] [-tile <8|16|32>] [-tiles <start>[:<end>]] [-palette </path/to/file.pal>] [-o </path/to/output/file.png>]\nExport mode. Draws the tiles of a ROM's unshuffled gfx region to a PNG sheet, 16 tiles wide, in grays unless a palette is given\n",
+	"importDesc":      "-sheet </path/to/tiles.png> -z </path/to/ROM.zip> [-n <ROM set name>] [-tile <8|16|32>] [-tiles <start>[:<end>]] [-palette </path/to/file.pal>] [-o </path/to/output/file.zip>]\nImport mode. Encodes the tiles of a PNG sheet laid out like the export flag's into a ROM's gfx region, from the tiles flag's start, or only up to its end. A paletted PNG's pixels are taken as pens, others are quantized to the palette. Output is a full ROM .zip and .mra patches of the changes next to it\n",
+	"sheetDesc":       "Specifies an input PNG tile sheet. Required with the import flag\n",
+	"splitDesc":       "-b </path/to/region.bin> [-z </path/to/ROM.zip> | -n <ROM set name>] [-region <maincpu|audiocpu|qsound|gfx|key>] [-o </path/to/output/file.zip>]\nSplit mode. Splits a region's .bin, as the c flag or a region editor writes it, back into the ROM set's files, interleaved and byte swapped as MAME loads them. With z, output is a full ROM .zip with the region's files replaced, otherwise a .zip of the region's files\n",
+	"regionDesc":      "Specifies the region the split flag splits, maincpu, audiocpu, qsound, gfx or key. Optional, defaults to maincpu\n",
+	"tileDesc":        "Specifies the width and height of the export and import flags' tiles, 8, 16 or 32. Optional, defaults to 16\n",
+	"tilesDesc":       "<start>[:<end>]\nSpecifies a tile number, or a range up to but not including end, for the export flag to draw instead of every tile, or the import flag to encode from. Optional\n",
+	"paletteDesc":     "Specifies the 16 colors the export flag draws tiles with, and the import flag quantizes to, as a JASC-PAL .pal or big endian CPS2 palette words. Optional, defaults to grays from black for pen 0 to white for pen 15\n",
+	"layoutDesc":      "Specifies the layout the convert flag writes, split, merged or nonmerged. Required with the convert flag\n",
+	"mameFileDesc":    "Specifies a local copy of MAME's cps2.cpp driver source. Required with the genroms flag\n",
+	"mapFileDesc":     "Specifies a code map file. Output with the merge flag. Optional with the e flag, which then only encrypts the b flag input where the map says it's code, taking b as a merged image like the merge flag writes unless data is given\n",
+	"dataFileDesc":    "Specifies a big endian .bin of the maincpu as the CPU reads data, e.g. from the c flag. Optional with the e flag, which then takes b as the opcode view and stores data as is where the map says it's data, or where only data was edited\n",
+	"suggestDesc":     "Tries the keys of the other ROM sets in the z flag input, or in .zips next to it, when a decrypted maincpu doesn't look like a 68000 program, and lists those that give a plausible one. Optional\n",
+	"forceDesc":       "Carries on when a decrypted maincpu doesn't look like a 68000 program, which usually means the ROM set name is wrong. Optional\n",
+	"xorFileDesc":     "Specifies a big endian .xor table to decrypt/encrypt with instead of the ROM's key, which may then be missing from the z flag input. Optional with the d and e flags, or instead of b with the recover flag\n",
+	"keyFileDesc":     "Specifies an input .key file, or a ROM .zip or directory to take the key from. Required with the rekey flag, optional with the key flag\n",
+	"keySetDesc":      "Specifies the ROM set name of a keyfile .zip. Optional, by default it's identified like the n flag's\n",
+	"masterKey1Desc":  "Specifies the first master key, in hex. Required with the key flag when there's no input key\n",
+	"masterKey2Desc":  "Specifies the second master key, in hex. Required with the key flag when there's no input key\n",
+	"limitDesc":       "Specifies the upper limit of the encrypted address range, in hex and a multiple of 4000. Required with the key flag when there's no input key\n",
+	"jsonDesc":        "Prints results as JSON instead of text. Optional with the key, bench and audit flags\n",
+	"verifyDesc":      "Crypts the d and e flag output back and compares it with the input, reporting the first mismatching addresses. After e the new maincpu files are read back from the output .zip. Optional\n",
+	"workersDesc":     "Specifies how many threads to decrypt/encrypt with. Optional, defaults to one per CPU\n",
 }
 
 var errorStrings = map[string]string{
+	"badHex":        "%s is not a valid hex value",
+	"phoenixKey":    "phoenix ROM key is not a null key",
+	"phoenixMain":   "phoenix ROM maincpu doesn't match the decrypted binary",
+	"noKeyFile":     "-keyfile input .key file or ROM .zip is required for this operation",
+	"noKeyParams":   "-key1, -key2 and -limit are required to create a key without an input key",
+	"badRange":      "-a address range must be <start>[:<end>], e.g. 0x400 or 0x400:0x800",
+	"badTileRange":  "-tiles range must be <start>[:<end>], e.g. 0x100 or 0x100:0x200",
+	"badTileSize":   "-tile must be 8, 16 or 32",
 	"diffSize":      "binaries differ in size",
 	"noBinFile":     "-b input .bin file is required for this operation",
+	"noMameFile":    "-mame input MAME driver source is required for this operation",
+	"noRomDefs":     "no ROM set definitions found in %s",
+	"noLayout":      "-layout must be split, merged or nonmerged for this operation",
+	"noSheetFile":   "-sheet input PNG tile sheet is required for this operation",
+	"noRomSetName":  "-n ROM set name or -z input ROM .zip is required for this operation",
+	"badRegion":     "-region must be maincpu, audiocpu, qsound, gfx or key",
 	"noMraFile":     "-r input .mra is required for this operation",
 	"noRomFile":     "-z input ROM .zip is required for this operation",
 	"noDiffRomFile": "-x input modified ROM .zip is required for this operation",
-	"noRomSetName":  "-n ROM set name is required for this operation",
 	"romParseErr":   "Something went wrong parsing the ROMs",
 }
 
@@ -77,12 +128,18 @@ func done(msg string) {
 	log(green(bold("[+]")), msg)
 }
 func log(glyph string, msg string) {
+	if Quiet {
+		return
+	}
 	if !strings.HasSuffix(msg, "\n") {
 		msg += "\n"
 	}
 	fmt.Printf("%s %s", glyph, msg)
 }
 
+// Quiet silences the Logger, e.g. while printing JSON
+var Quiet = false
+
 var Strings = StringResources{flagStrings, errorStrings, infoStrings}
 var LogText = LogAliases{blue, bold, green, red, yellow}
 var Logger = Log{info, warn, error, done}
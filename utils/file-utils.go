@@ -2,7 +2,6 @@ package utils
 
 import (
 	"archive/zip"
-	"io"
 	"os"
 	"path/filepath"
 )
@@ -42,6 +41,9 @@ func GetZipFileReader(file_path string) (*zip.ReadCloser, error) {
 	return zip.OpenReader(filepath.Clean(file_path))
 }
 
+// WriteBytesToFile writes bytes to the file at file_path, whose directory must
+// exist. It used to write to the path's base name in the working directory,
+// which only worked for outputs there.
 func WriteBytesToFile(file_path string, bytes []byte) error {
 	_, err := os.Stat(filepath.Dir(file_path))
 	if err != nil {
@@ -50,7 +52,7 @@ func WriteBytesToFile(file_path string, bytes []byte) error {
 	if os.IsNotExist(err) {
 		return err
 	}
-	err = os.WriteFile(filepath.Base(file_path), bytes, 0644)
+	err = os.WriteFile(filepath.Clean(file_path), bytes, 0644)
 	return err
 }
 
@@ -59,29 +61,17 @@ func SwapFileBytes(file_path string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	i := 0
-	for i < len(fileContents) {
-		tmp := fileContents[i]
-		fileContents[i] = fileContents[i+1]
-		fileContents[i+1] = tmp
-		i += 2
-	}
-	return fileContents, err
+	return SwapBytes(fileContents), err
 }
 
-func UnzipFilesToFilenameContentMap(zipFile *zip.ReadCloser) (map[string][]byte, error) {
-	var bytes = make(map[string][]byte, len(zipFile.File))
-	for _, file := range zipFile.File {
-		r, err := file.Open()
-		if err != nil {
-			return nil, err
-		}
-		fileContents, err := io.ReadAll(r)
-		if err != nil {
-			return nil, err
-		}
-		bytes[file.Name] = fileContents
-		defer r.Close()
+// SwapBytes swaps every pair of bytes in place and returns bytes
+func SwapBytes(bytes []byte) []byte {
+	i := 0
+	for i < len(bytes) {
+		tmp := bytes[i]
+		bytes[i] = bytes[i+1]
+		bytes[i+1] = tmp
+		i += 2
 	}
-	return bytes, nil
+	return bytes
 }
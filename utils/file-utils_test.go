@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteBytesToFile(t *testing.T) {
+	dir := t.TempDir()
+	file_path := filepath.Join(dir, "out", "..", "maincpu.bin")
+	if err := WriteBytesToFile(file_path, []byte{1, 2, 3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	contents, err := os.ReadFile(filepath.Join(dir, "maincpu.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(contents, []byte{1, 2, 3, 4}) {
+		t.Errorf("wrote % x, expected 01 02 03 04", contents)
+	}
+	if err := WriteBytesToFile(filepath.Join(dir, "missing", "maincpu.bin"), nil); err == nil {
+		t.Error("writing into a missing directory didn't fail")
+	}
+}